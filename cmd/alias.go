@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"token-manager/internal/config"
+)
+
+// applyAliasDefaults sets the flags which are not specified on the command line to the defaults of
+// the alias, if the token reference is an alias like @deploy-bot.
+func applyAliasDefaults(cmd *cobra.Command, reference string) error {
+	if !config.IsAlias(reference) {
+		return nil
+	}
+	alias, err := config.Lookup(reference)
+	if err != nil {
+		return err
+	}
+
+	defaults := map[string]string{"url": alias.URL}
+	if alias.Duration != 0 {
+		defaults["duration"] = strconv.Itoa(alias.Duration)
+	}
+	if !cmd.Flags().Changed("project") && !cmd.Flags().Changed("group") {
+		defaults["project"] = alias.Project
+		defaults["group"] = alias.Group
+	}
+
+	for name, value := range defaults {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed || value == "" {
+			continue
+		}
+		if err = cmd.Flags().Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s of alias %s, %w", name, reference, err)
+		}
+	}
+	return nil
+}
@@ -34,6 +34,12 @@ func newCreateCommand() *gitlabCreateCommand {
 	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		var err error
 
+		if len(args) > 0 {
+			if err = applyAliasDefaults(cmd, args[0]); err != nil {
+				return err
+			}
+		}
+
 		if c.Parent() != nil && c.Parent().PersistentPreRunE != nil {
 			err = c.Parent().PersistentPreRunE(cmd, args)
 		}
@@ -61,6 +67,18 @@ func newCreateCommand() *gitlabCreateCommand {
 			return err
 		}
 
+		copyTo, err := cmd.Flags().GetStringArray("copy-to")
+		if err != nil {
+			return err
+		}
+		for _, destination := range copyTo {
+			ref, err := factory.NewSecretReferenceFromURL(cmd.Context(), destination)
+			if err != nil {
+				return err
+			}
+			c.createToken.CopyTo = append(c.createToken.CopyTo, ref)
+		}
+
 		return nil
 	}
 
@@ -78,6 +96,8 @@ func newCreateCommand() *gitlabCreateCommand {
 	c.Flags().StringVarP(&c.createToken.Name, "name", "n", "", "name of the gitlab token to create")
 	c.Flags().StringSliceVarP(&c.createToken.Scopes, "scope", "s", []string{"read_repository"}, "scopes for the token, see https://docs.gitlab.com/ee/user/profile/personal_access_tokens.html#personal-access-token-scopes")
 	c.Flags().VarP(&c.createToken.AccessLevel, "access-level", "a", "of the token: guest, reporter, developer, maintainer, owner")
+	c.Flags().BoolVar(&c.createToken.CreateSecret, "create-secret", false, "create the secret in the secret store, if it does not exist")
+	c.Flags().StringArray("copy-to", nil, "URL of another secret to write the new token to, may be repeated")
 
 	c.MarkFlagRequired("name")
 	c.MarkFlagRequired("access-level")
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"token-manager/internal/factory"
+	"token-manager/internal/secretreference"
+
+	"github.com/spf13/cobra"
+)
+
+func newMetadataCmd() *cobra.Command {
+	c := new(cobra.Command)
+	c.Use = "metadata token-url"
+	c.Short = "Show the expiry date, update time and version of a secret in the secret store"
+	c.Args = cobra.MinimumNArgs(1)
+
+	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if c.Parent() != nil && c.Parent().PersistentPreRunE != nil {
+			if err := c.Parent().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		tokenReference, err := factory.NewSecretReferenceFromURL(cmd.Context(), args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		metadata, err := secretreference.ReadMetadata(cmd.Context(), tokenReference)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = fmt.Printf("expires-at: %s\nupdated-at: %s\nversion: %s\n",
+			formatTime(metadata.ExpiresAt, time.DateOnly), formatTime(metadata.UpdatedAt, time.RFC3339), metadata.Version)
+		return err
+	}
+
+	return c
+}
+
+// formatTime formats t, or returns unknown if t is not set.
+func formatTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format(layout)
+}
@@ -3,8 +3,10 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"token-manager/internal/factory"
+	"token-manager/internal/secretreference"
 
 	"github.com/spf13/cobra"
 )
@@ -14,6 +16,7 @@ func newReadCmd() *cobra.Command {
 	c.Use = "read token-url"
 	c.Short = "Read a secret from the secret store"
 	c.Args = cobra.MinimumNArgs(1)
+	field := c.Flags().String("field", "token", "of the json payload to read: "+strings.Join(secretreference.PayloadFields, ", "))
 
 	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		if c.Parent() != nil && c.Parent().PersistentPreRunE != nil {
@@ -30,11 +33,15 @@ func newReadCmd() *cobra.Command {
 		if err != nil {
 			log.Fatal(err)
 		}
-		token, err := tokenReference.Read(cmd.Context())
+		payload, err := secretreference.ReadPayload(cmd.Context(), tokenReference)
 		if err != nil {
 			log.Fatal(err)
 		}
-		_, err = fmt.Printf("%s", token)
+		value, err := payload.Field(*field)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = fmt.Printf("%s", value)
 		return err
 	}
 
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"net/url"
+
+	"token-manager/internal/factory"
+
+	"github.com/spf13/cobra"
+
+	"token-manager/internal/gitlab"
+)
+
+type rollbackCommand struct {
+	cobra.Command
+	rollback gitlab.RollbackCommand
+}
+
+func newRollbackCmd() *rollbackCommand {
+	c := &rollbackCommand{
+		Command: cobra.Command{
+			Use:   "rollback token-url",
+			Short: "restore an earlier version of the token in the secret store",
+			Args:  cobra.ExactArgs(1),
+			Long: `restores an earlier version of the Gitlab token in the secret store, if it is still valid.
+Without --to-version, the version before the current one is restored.`,
+		},
+	}
+
+	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		var err error
+
+		if len(args) > 0 {
+			if err = applyAliasDefaults(cmd, args[0]); err != nil {
+				return err
+			}
+		}
+
+		if c.Parent() != nil && c.Parent().PersistentPreRunE != nil {
+			err = c.Parent().PersistentPreRunE(cmd, args)
+		}
+		return err
+	}
+
+	c.PreRunE = func(cmd *cobra.Command, args []string) error {
+		serverURL, err := url.Parse(c.rollback.Url)
+		if err != nil || (serverURL.Scheme != "https" || serverURL.Host == "") {
+			return errors.New("a valid https base url must be provided")
+		}
+
+		c.rollback.Token, err = factory.NewSecretReferenceFromURL(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		err := c.rollback.Rollback(cmd.Context())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return nil
+	}
+	c.Flags().SortFlags = false
+	c.Flags().StringVar(&c.rollback.ToVersion, "to-version", "", "version of the secret to restore")
+	c.Flags().StringVar(&c.rollback.Url, "url", "https://gitlab.com", "of the Gitlab instance the token belongs to")
+	return c
+}
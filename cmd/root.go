@@ -20,6 +20,8 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	rootCmd.AddCommand(newReadCmd())
+	rootCmd.AddCommand(newMetadataCmd())
+	rootCmd.AddCommand(&newRollbackCmd().Command)
 	rootCmd.AddCommand(newGitlabCmdGroup())
 
 	err := rootCmd.Execute()
@@ -34,6 +34,12 @@ func newRotateCommand() *gitlabRotateCommand {
 	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		var err error
 
+		if len(args) > 0 {
+			if err = applyAliasDefaults(cmd, args[0]); err != nil {
+				return err
+			}
+		}
+
 		if c.Parent() != nil && c.Parent().PersistentPreRunE != nil {
 			err = c.Parent().PersistentPreRunE(cmd, args)
 		}
@@ -57,6 +63,12 @@ func newRotateCommand() *gitlabRotateCommand {
 		if c.gitlabRotate.Group, err = cmd.Flags().GetString("group"); err != nil {
 			return err
 		}
+		if c.gitlabRotate.KeepVersions, err = cmd.Flags().GetInt("keep-versions"); err != nil {
+			return err
+		}
+		if c.gitlabRotate.KeepVersions < 0 {
+			return errors.New("the number of versions to keep must be a non-negative number")
+		}
 		if c.gitlabRotate.Project != "" && c.gitlabRotate.Group != "" {
 			return errors.New("--project and --project cannot be used together")
 		}
@@ -70,6 +82,18 @@ func newRotateCommand() *gitlabRotateCommand {
 			return err
 		}
 
+		copyTo, err := cmd.Flags().GetStringArray("copy-to")
+		if err != nil {
+			return err
+		}
+		for _, destination := range copyTo {
+			ref, err := factory.NewSecretReferenceFromURL(cmd.Context(), destination)
+			if err != nil {
+				return err
+			}
+			c.gitlabRotate.CopyTo = append(c.gitlabRotate.CopyTo, ref)
+		}
+
 		return nil
 	}
 
@@ -84,5 +108,7 @@ func newRotateCommand() *gitlabRotateCommand {
 	c.Flags().SortFlags = false
 	c.Flags().String("project", "", "name of the gitlab project the token belongs to")
 	c.Flags().String("group", "", "name of the gitlab group the token belongs to")
+	c.Flags().Int("keep-versions", 0, "number of versions of the secret to keep after rotation, 0 keeps all")
+	c.Flags().StringArray("copy-to", nil, "URL of another secret to write the rotated token to, may be repeated")
 	return c
 }
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownAlias is returned when a reference alias is not defined in the configuration files.
+var ErrUnknownAlias = errors.New("unknown alias")
+
+// Alias is a named token reference, with the defaults for the commands managing the token.
+type Alias struct {
+	// Reference is the URL of the secret holding the token.
+	Reference string `yaml:"reference"`
+	// URL is the Gitlab instance the token belongs to.
+	URL string `yaml:"url"`
+	// Project or Group is the gitlab project or group the token belongs to.
+	Project string `yaml:"project"`
+	Group   string `yaml:"group"`
+	// Duration is the validity of a new or rotated token in days.
+	Duration int `yaml:"duration"`
+}
+
+// Config is the content of a configuration file.
+type Config struct {
+	Aliases map[string]Alias `yaml:"aliases"`
+}
+
+// Paths are the configuration files, in increasing order of precedence.
+var Paths = defaultPaths()
+
+// defaultPaths returns ~/.config/token-manager/config.yaml and ./.token-manager.yaml.
+func defaultPaths() []string {
+	paths := make([]string, 0, 2)
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "token-manager", "config.yaml"))
+	}
+	return append(paths, ".token-manager.yaml")
+}
+
+// IsAlias returns true if the reference is an alias, like @deploy-bot.
+func IsAlias(reference string) bool {
+	return strings.HasPrefix(reference, "@")
+}
+
+// Load reads the configuration files. An alias in a later file replaces the alias with the same name
+// in an earlier file. Missing files are ignored.
+func Load() (Config, error) {
+	config := Config{Aliases: make(map[string]Alias)}
+	for _, path := range Paths {
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return config, err
+		}
+
+		var file Config
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return config, fmt.Errorf("invalid configuration file %s, %w", path, err)
+		}
+		for name, alias := range file.Aliases {
+			config.Aliases[name] = alias
+		}
+	}
+	return config, nil
+}
+
+// Lookup returns the alias with the name, with or without the leading @, from the configuration files.
+func Lookup(name string) (Alias, error) {
+	config, err := Load()
+	if err != nil {
+		return Alias{}, err
+	}
+	return config.Lookup(name)
+}
+
+// Lookup returns the alias with the name, with or without the leading @.
+func (c Config) Lookup(name string) (Alias, error) {
+	name = strings.TrimPrefix(name, "@")
+	alias, ok := c.Aliases[name]
+	if !ok {
+		return alias, fmt.Errorf("%w @%s, expected one of the aliases in %s", ErrUnknownAlias, name, strings.Join(Paths, ", "))
+	}
+	if alias.Reference == "" || IsAlias(alias.Reference) {
+		return alias, fmt.Errorf("alias @%s must have the URL of a secret as reference", name)
+	}
+	return alias, nil
+}
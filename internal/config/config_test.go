@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfigs writes the configuration files and uses them for the test. An empty content is a missing file.
+func writeConfigs(t *testing.T, contents ...string) {
+	paths := make([]string, 0, len(contents))
+	for _, content := range contents {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if content != "" {
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		paths = append(paths, path)
+	}
+	saved := Paths
+	Paths = paths
+	t.Cleanup(func() { Paths = saved })
+}
+
+func TestLookup(t *testing.T) {
+	writeConfigs(t, `aliases:
+  deploy-bot:
+    reference: arn:aws:ssm:eu-central-1:123456789012:parameter/gitlab/pat
+    url: https://gitlab.example.com
+    project: group/project
+    duration: 90
+  ci:
+    reference: gitlab://gitlab.com/groups/7/variables/TOKEN
+`, `aliases:
+  ci:
+    reference: mem://ci
+    group: "7"
+`, "")
+
+	alias, err := Lookup("@deploy-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Alias{
+		Reference: "arn:aws:ssm:eu-central-1:123456789012:parameter/gitlab/pat",
+		URL:       "https://gitlab.example.com",
+		Project:   "group/project",
+		Duration:  90,
+	}
+	if alias != want {
+		t.Errorf("Lookup() = %+v, want %+v", alias, want)
+	}
+
+	if alias, err = Lookup("ci"); err != nil || alias.Reference != "mem://ci" || alias.Group != "7" {
+		t.Errorf("expected the alias of the later file, got %+v, %v", alias, err)
+	}
+
+	if _, err = Lookup("@unknown"); !errors.Is(err, ErrUnknownAlias) {
+		t.Errorf("expected an unknown alias error, got %v", err)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	writeConfigs(t, "aliases:\n  bot:\n    ref: mem://bot\n", "")
+	if _, err := Load(); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+
+	writeConfigs(t, "aliases:\n  bot:\n    reference: \"@other\"\n", "")
+	if _, err := Lookup("bot"); err == nil || errors.Is(err, ErrUnknownAlias) {
+		t.Errorf("expected an error for an alias referring to an alias, got %v", err)
+	}
+}
@@ -3,16 +3,27 @@ package env
 import (
 	"context"
 	"errors"
+	"log"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 
+	"token-manager/internal/config"
 	"token-manager/internal/factory"
 	"token-manager/internal/secretreference"
 )
 
-// resolve resolves environment variable secret references to their value
+// environmentSchemes are the schemes of references to secret stores, which are resolved in environment
+// variables. Other URLs, like file:// URLs or the schemes of plugins, may well be the value of an unrelated
+// variable, and are only resolved through an alias.
+var environmentSchemes = []string{"op", "arn", "ssm", "asm", "gsm", "gitlab", "vault", "azkv", "bws"}
+
+// resolve resolves environment variable secret references to their value. A value like @deploy-bot
+// refers to the alias in the configuration files, which are loaded once. A value which is not a known
+// alias is left as is, as is any alias when the configuration cannot be loaded.
 func resolve(ctx context.Context, env []string, factoryMethod func(context.Context, string) (secretreference.SecretReference, error)) (map[string]string, error) {
+	var aliases *config.Config
 	result := make(map[string]string)
 	for _, variable := range env {
 		parts := strings.SplitN(variable, "=", 2)
@@ -21,6 +32,23 @@ func resolve(ctx context.Context, env []string, factoryMethod func(context.Conte
 		}
 		name := parts[0]
 		referenceURL := parts[1]
+		if config.IsAlias(referenceURL) {
+			if aliases == nil {
+				loaded, err := config.Load()
+				if err != nil {
+					log.Printf("ignoring aliases in environment variables, %s", err)
+					loaded = config.Config{}
+				}
+				aliases = &loaded
+			}
+			alias, err := aliases.Lookup(referenceURL)
+			if err != nil {
+				continue
+			}
+			referenceURL = alias.Reference
+		} else if !isReference(referenceURL) {
+			continue
+		}
 		_, err := url.Parse(referenceURL)
 		if err != nil {
 			continue
@@ -32,6 +60,9 @@ func resolve(ctx context.Context, env []string, factoryMethod func(context.Conte
 		if err != nil {
 			return nil, err
 		}
+		if secretreference.IsWriteOnly(secretReference) {
+			continue
+		}
 		value, err := secretReference.Read(ctx)
 		if err != nil {
 			return nil, err
@@ -41,6 +72,12 @@ func resolve(ctx context.Context, env []string, factoryMethod func(context.Conte
 	return result, nil
 }
 
+// isReference returns true if the value is a URL with one of the environmentSchemes.
+func isReference(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && slices.Contains(environmentSchemes, u.Scheme)
+}
+
 // UpdateEnvironment updates the environment variables secret references with the actual value.
 func UpdateEnvironment(ctx context.Context) error {
 	variables, err := resolve(ctx, os.Environ(), factory.NewSecretReferenceFromURL)
@@ -0,0 +1,107 @@
+package env
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"token-manager/internal/config"
+	"token-manager/internal/factory"
+	"token-manager/internal/secretreference"
+	"token-manager/internal/secretreference/mem"
+)
+
+// useConfig writes the configuration file and uses it for the test.
+func useConfig(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), ".token-manager.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	saved := config.Paths
+	config.Paths = []string{path}
+	t.Cleanup(func() { config.Paths = saved })
+}
+
+// fakeFactory resolves vault://secret/<name> to the in-memory secret with the name, and fails the test
+// for any other URL which is not an in-memory secret.
+func fakeFactory(t *testing.T) func(context.Context, string) (secretreference.SecretReference, error) {
+	return func(ctx context.Context, referenceURL string) (secretreference.SecretReference, error) {
+		if name, ok := strings.CutPrefix(referenceURL, "vault://secret/"); ok {
+			referenceURL = "mem://" + name
+		} else if !strings.HasPrefix(referenceURL, "mem://") {
+			t.Errorf("unexpected reference %s", referenceURL)
+			return nil, factory.UnsupportedSchemeError
+		}
+		return factory.NewSecretReferenceFromURL(ctx, referenceURL)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Cleanup(mem.Reset)
+	mem.Lookup("pat").Set("glpat-secret")
+	mem.Lookup("deploy").Set("glpat-deploy")
+	useConfig(t, `aliases:
+  deploy-bot:
+    reference: mem://deploy
+`)
+
+	variables, err := resolve(context.Background(), []string{
+		"GITLAB_TOKEN=vault://secret/pat",
+		"DEPLOY_TOKEN=@deploy-bot",
+		"NPM_SCOPE=@myorg",
+		"HOME=/home/bot",
+	}, fakeFactory(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"GITLAB_TOKEN": "glpat-secret", "DEPLOY_TOKEN": "glpat-deploy"}
+	if len(variables) != len(expected) {
+		t.Errorf("resolve() = %v, expected %v", variables, expected)
+	}
+	for name, value := range expected {
+		if variables[name] != value {
+			t.Errorf("resolve() = %v, expected %v", variables, expected)
+		}
+	}
+}
+
+func TestResolveLeavesUnrelatedURLs(t *testing.T) {
+	t.Cleanup(mem.Reset)
+	useConfig(t, "aliases: {}")
+
+	variables, err := resolve(context.Background(), []string{
+		"APP_CONFIG=file:///etc/app/config.yaml",
+		"CACHE=mem://local",
+		"CLUSTER=k8s://production/default/app",
+		"PASSWORD_STORE=pass://personal",
+		"SECRETS=sops://secrets.yaml#/app",
+		"REPOSITORY=github://xebia/token-manager/actions/secrets/NAME",
+		"DATABASE_URL=postgres://app@localhost/app",
+		"SOURCE=s3://bucket/key",
+	}, fakeFactory(t))
+	if err != nil {
+		t.Fatalf("expected unrelated URLs not to fail the resolution, got %v", err)
+	}
+	if len(variables) != 0 {
+		t.Errorf("resolve() = %v, expected unrelated URLs to be left alone", variables)
+	}
+}
+
+func TestResolveIgnoresInvalidConfig(t *testing.T) {
+	t.Cleanup(mem.Reset)
+	mem.Lookup("pat").Set("glpat-secret")
+	useConfig(t, "aliases: [")
+
+	variables, err := resolve(context.Background(), []string{
+		"GITLAB_TOKEN=vault://secret/pat",
+		"NPM_SCOPE=@myorg",
+	}, fakeFactory(t))
+	if err != nil {
+		t.Fatalf("expected an invalid configuration file not to fail the resolution, got %v", err)
+	}
+	if len(variables) != 1 || variables["GITLAB_TOKEN"] != "glpat-secret" {
+		t.Errorf("resolve() = %v", variables)
+	}
+}
@@ -7,18 +7,40 @@ import (
 
 	"token-manager/internal/secretreference/gitlab"
 
+	"token-manager/internal/config"
 	"token-manager/internal/secretreference"
+	"token-manager/internal/secretreference/asm"
+	"token-manager/internal/secretreference/azkv"
+	"token-manager/internal/secretreference/bws"
+	"token-manager/internal/secretreference/file"
+	"token-manager/internal/secretreference/github"
 	"token-manager/internal/secretreference/gsm"
+	"token-manager/internal/secretreference/k8s"
+	"token-manager/internal/secretreference/mem"
 	"token-manager/internal/secretreference/onepassword"
+	"token-manager/internal/secretreference/pass"
+	"token-manager/internal/secretreference/plugin"
+	"token-manager/internal/secretreference/sops"
 	"token-manager/internal/secretreference/ssm"
+	"token-manager/internal/secretreference/vault"
 )
 
 var factoryMethods = map[string]func(context.Context, *url.URL) (secretreference.SecretReference, error){
 	"op":     onepassword.NewFromURL,
 	"gsm":    gsm.NewFromURL,
-	"arn":    ssm.NewFromURL,
+	"arn":    newFromARN,
 	"ssm":    ssm.NewFromURL,
 	"gitlab": gitlab.NewFromURL,
+	"vault":  vault.NewFromURL,
+	"asm":    asm.NewFromURL,
+	"azkv":   azkv.NewFromURL,
+	"k8s":    k8s.NewFromURL,
+	"file":   file.NewFromURL,
+	"sops":   sops.NewFromURL,
+	"bws":    bws.NewFromURL,
+	"pass":   pass.NewFromURL,
+	"github": github.NewFromURL,
+	"mem":    mem.NewFromURL,
 }
 
 var (
@@ -26,7 +48,17 @@ var (
 	UnsupportedSchemeError = errors.New("Unsupported scheme")
 )
 
+// NewSecretReferenceFromURL creates a reference to the secret at the URL, or at the reference of an
+// alias like @deploy-bot from the configuration files.
 func NewSecretReferenceFromURL(ctx context.Context, referenceURL string) (secretreference.SecretReference, error) {
+	if config.IsAlias(referenceURL) {
+		alias, err := config.Lookup(referenceURL)
+		if err != nil {
+			return nil, err
+		}
+		referenceURL = alias.Reference
+	}
+
 	var parsedURL *url.URL
 	parsedURL, err := url.Parse(referenceURL)
 	if err != nil {
@@ -34,10 +66,25 @@ func NewSecretReferenceFromURL(ctx context.Context, referenceURL string) (secret
 	}
 	newFromURL, ok := factoryMethods[parsedURL.Scheme]
 	if !ok {
-		return nil, UnsupportedSchemeError
+		if _, found := plugin.Command(parsedURL.Scheme); !found {
+			return nil, UnsupportedSchemeError
+		}
+		newFromURL = plugin.NewFromURL
+	}
+
+	ref, err := newFromURL(ctx, parsedURL)
+	if err != nil {
+		return nil, err
 	}
+	return secretreference.WithFormat(ref, parsedURL.Query())
+}
 
-	return newFromURL(ctx, parsedURL)
+// newFromARN creates a reference to an AWS SSM parameter or Secrets Manager secret from an ARN.
+func newFromARN(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	if asm.IsSecretARN(referenceURL.Opaque) {
+		return asm.NewFromURL(ctx, referenceURL)
+	}
+	return ssm.NewFromURL(ctx, referenceURL)
 }
 
 func init() {
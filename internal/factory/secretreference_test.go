@@ -2,9 +2,15 @@ package factory
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"token-manager/internal/config"
+	"token-manager/internal/secretreference"
 )
 
 func Must[T any](obj T, err error) T {
@@ -49,6 +55,81 @@ func TestNewFromURL(t *testing.T) {
 			args{"arn:aws:ssm:eu-central-1:123456789012:parameter/gitlab/pat"},
 			false,
 		},
+		{
+			"AWS Secrets Manager secret",
+			args{"asm:///gitlab/pat"},
+			false,
+		},
+		{
+			"AWS Secrets Manager secret ARN",
+			args{"arn:aws:secretsmanager:eu-central-1:123456789012:secret:gitlab/pat-AbCdEf"},
+			false,
+		},
+		{
+			"Azure Key Vault secret",
+			args{"azkv://my-vault/gitlab-pat"},
+			false,
+		},
+		{
+			"Kubernetes secret",
+			args{"k8s://kind-kind/argocd/repo-creds/password"},
+			false,
+		},
+		{
+			"Kubernetes secret in current context",
+			args{"k8s:///argocd/repo-creds/password"},
+			false,
+		},
+		{
+			"local file",
+			args{"file:///home/me/.config/gitlab/token"},
+			false,
+		},
+		{
+			"field in local JSON file",
+			args{"file:///home/me/.config/credentials.json#/gitlab/token"},
+			false,
+		},
+		{
+			"value in SOPS encrypted file",
+			args{"sops://config/production.yaml#/gitlab/token"},
+			false,
+		},
+		{
+			"Bitwarden Secrets Manager secret",
+			args{"bws://e325ea69-a3ab-4dff-836f-b02e013fe530/GITLAB_TOKEN"},
+			false,
+		},
+		{
+			"password store entry",
+			args{"pass://gitlab.com/personal-access-token"},
+			false,
+		},
+		{
+			"GitHub Actions repository secret",
+			args{"github://xebia/token-manager/actions/secrets/GITLAB_TOKEN"},
+			false,
+		},
+		{
+			"GitHub Actions organization secret",
+			args{"github://xebia/actions/secrets/GITLAB_TOKEN"},
+			false,
+		},
+		{
+			"in-memory secret",
+			args{"mem://gitlab-pat?env=GITLAB_TOKEN&fail-update=1"},
+			false,
+		},
+		{
+			"in-memory secret with json payload",
+			args{"mem://gitlab-pat?format=json"},
+			false,
+		},
+		{
+			"Hashicorp Vault KV secret",
+			args{"vault://secret/gitlab/pat#token"},
+			false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -63,3 +144,26 @@ func TestNewFromURL(t *testing.T) {
 		})
 	}
 }
+
+func TestNewFromAlias(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "aliases:\n  deploy-bot:\n    reference: mem://deploy-bot?format=json\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	saved := config.Paths
+	config.Paths = []string{path}
+	t.Cleanup(func() { config.Paths = saved })
+
+	got, err := NewSecretReferenceFromURL(context.Background(), "@deploy-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secretreference.StoresPayload(got) || fmt.Sprint(got) != "mem://deploy-bot?format=json" {
+		t.Errorf("NewSecretReferenceFromURL() got = %v", got)
+	}
+
+	if _, err = NewSecretReferenceFromURL(context.Background(), "@unknown"); !errors.Is(err, config.ErrUnknownAlias) {
+		t.Errorf("expected an unknown alias error, got %v", err)
+	}
+}
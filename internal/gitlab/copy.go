@@ -0,0 +1,54 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// checkSecret checks whether the secret to store a new token in can be read. It returns true if the
+// secret does not exist and must be created, which requires createSecret and a secret store that supports it.
+func checkSecret(ctx context.Context, ref secretreference.SecretReference, createSecret bool) (bool, error) {
+	if secretreference.IsWriteOnly(ref) {
+		log.Printf("%s is write-only, skipping the check whether the secret exists", ref)
+		return false, nil
+	}
+	_, err := ref.Read(ctx)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, secretreference.ErrNotFound) {
+		return false, fmt.Errorf("The secret %s to store the token in, cannot be read, %s", ref, err)
+	}
+	if !createSecret || !secretreference.CanCreate(ref) {
+		return false, fmt.Errorf("The secret %s to store the token in, does not exist, %s", ref, err)
+	}
+	log.Printf("%s does not exist, the secret will be created", ref)
+	return true, nil
+}
+
+// copyToken writes the token to each of the secrets it is copied to, and reports the status per secret.
+// The secrets marked in create are created instead of updated. It returns the failed writes.
+func copyToken(ctx context.Context, copies []secretreference.SecretReference, create []bool, baseURL, token string, expiresAt time.Time) error {
+	var errs []error
+	for i, ref := range copies {
+		var err error
+		setPayload(ref, baseURL, token)
+		if i < len(create) && create[i] {
+			err = secretreference.Create(ctx, ref, token, expiresAt)
+		} else {
+			err = ref.Update(ctx, token, expiresAt)
+		}
+		if err != nil {
+			log.Printf("failed to copy the token to %s, %s", ref, err)
+			errs = append(errs, fmt.Errorf("failed to copy the token to %s, %w", ref, err))
+			continue
+		}
+		log.Printf("copied the token to %s", ref)
+	}
+	return errors.Join(errs...)
+}
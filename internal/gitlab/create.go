@@ -3,13 +3,13 @@ package gitlab
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
 	"os"
 	"time"
 
 	"github.com/xanzy/go-gitlab"
 
+	"token-manager/internal/rescue"
 	"token-manager/internal/secretreference"
 )
 
@@ -23,15 +23,24 @@ type CreateTokenCommand struct {
 	Duration       time.Duration
 	DurationInDays int
 	Name           string
+	CreateSecret   bool
+	CopyTo         []secretreference.SecretReference
 }
 
 func (c CreateTokenCommand) Create(ctx context.Context) error {
 	var err error
 	var token string
 	var adminClient *gitlab.Client
+	var createSecret bool
 
-	if _, err = c.Token.Read(ctx); err != nil {
-		return fmt.Errorf("The secret to store the token in, does not exist or cannot be read, %s", err)
+	if createSecret, err = checkSecret(ctx, c.Token, c.CreateSecret); err != nil {
+		return err
+	}
+	createCopies := make([]bool, len(c.CopyTo))
+	for i, ref := range c.CopyTo {
+		if createCopies[i], err = checkSecret(ctx, ref, c.CreateSecret); err != nil {
+			return err
+		}
 	}
 
 	adminClient, err = gitlab.NewClient(os.Getenv("GITLAB_TOKEN"), gitlab.WithBaseURL(c.Url))
@@ -91,12 +100,21 @@ func (c CreateTokenCommand) Create(ctx context.Context) error {
 		return errors.New("personal access token cannot be created using the API")
 	}
 
-	err = c.Token.Update(ctx, token, time.Time(*c.ExpirationDate()))
+	setPayload(c.Token, c.Url, token)
+	if createSecret {
+		err = secretreference.Create(ctx, c.Token, token, time.Time(*c.ExpirationDate()))
+	} else {
+		err = c.Token.Update(ctx, token, time.Time(*c.ExpirationDate()))
+	}
 	if err != nil {
 		log.Printf("Error storing the gitlab access token. Manual renewal and update to %s is required",
 			c.Token)
-		writeTokenToTemporaryFile(token)
-		return err
+	}
+
+	copyErr := copyToken(ctx, c.CopyTo, createCopies, c.Url, token, time.Time(*c.ExpirationDate()))
+	if err != nil || copyErr != nil {
+		rescue.WriteToken("gl-token-", token)
+		return errors.Join(err, copyErr)
 	}
 
 	log.Printf("new api token %s, will expire on %s",
@@ -0,0 +1,188 @@
+package gitlab
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"token-manager/internal/secretreference"
+	"token-manager/internal/secretreference/mem"
+)
+
+func TestCreate(t *testing.T) {
+	server := newFakeGitlab(t)
+	files := rescueFiles(t)
+	t.Setenv("SEED_TOKEN", "")
+
+	command := CreateTokenCommand{
+		Url:      server.URL,
+		Token:    newMemReference(t, "mem://project-token?env=SEED_TOKEN"),
+		Project:  "42",
+		Name:     "deploy",
+		Scopes:   []string{"read_repository"},
+		Duration: 30 * 24 * time.Hour,
+	}
+	if err := command.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if updates := mem.Lookup("project-token").Updates(); len(updates) != 1 || updates[0].Token != "glpat-new" {
+		t.Errorf("expected the new token to be stored, got %v", updates)
+	}
+	if len(files()) != 0 {
+		t.Errorf("unexpected rescue files %v", files())
+	}
+
+	command.Name = "existing"
+	if err := command.Create(context.Background()); err == nil {
+		t.Errorf("expected an error when a token with the same name exists")
+	}
+}
+
+func TestCreateRescuesTokenOnFailedUpdate(t *testing.T) {
+	server := newFakeGitlab(t)
+	files := rescueFiles(t)
+	t.Setenv("SEED_TOKEN", "")
+
+	command := CreateTokenCommand{
+		Url:      server.URL,
+		Token:    newMemReference(t, "mem://project-token?env=SEED_TOKEN&fail-update=1"),
+		Project:  "42",
+		Name:     "deploy",
+		Duration: 30 * 24 * time.Hour,
+	}
+	if err := command.Create(context.Background()); err == nil {
+		t.Fatal("expected the failed update to be returned")
+	}
+
+	rescued := files()
+	if len(rescued) != 1 {
+		t.Fatalf("expected a single rescue file, got %v", rescued)
+	}
+	if content, _ := os.ReadFile(rescued[0]); string(content) != "glpat-new" {
+		t.Errorf("expected the new token in the rescue file, got %s", content)
+	}
+}
+
+func TestCreateRequiresExistingSecret(t *testing.T) {
+	server := newFakeGitlab(t)
+	command := CreateTokenCommand{
+		Url:     server.URL,
+		Token:   newMemReference(t, "mem://missing"),
+		Project: "42",
+		Name:    "deploy",
+	}
+	if err := command.Create(context.Background()); err == nil {
+		t.Errorf("expected an error when the secret does not exist")
+	}
+}
+
+func TestCreateSecret(t *testing.T) {
+	server := newFakeGitlab(t)
+	command := CreateTokenCommand{
+		Url:          server.URL,
+		Token:        newMemReference(t, "mem://new-secret"),
+		Project:      "42",
+		Name:         "deploy",
+		Duration:     30 * 24 * time.Hour,
+		CreateSecret: true,
+	}
+	if err := command.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if updates := mem.Lookup("new-secret").Updates(); len(updates) != 1 || updates[0].Token != "glpat-new" {
+		t.Errorf("expected the secret to be created with the new token, got %v", updates)
+	}
+}
+
+func TestCreateSecretFailsWhenSecretCannotBeRead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	command := CreateTokenCommand{
+		Url:          server.URL,
+		Token:        newMemReference(t, "mem://unreadable"),
+		Project:      "42",
+		Name:         "deploy",
+		Duration:     30 * 24 * time.Hour,
+		CreateSecret: true,
+	}
+	mem.Lookup("unreadable").FailReads(1)
+	if err := command.Create(context.Background()); err == nil {
+		t.Fatal("expected an error when the secret cannot be read")
+	}
+	if updates := mem.Lookup("unreadable").Updates(); len(updates) != 0 {
+		t.Errorf("expected no secret to be created, got %v", updates)
+	}
+}
+
+// missingReference is a reference to a secret which does not exist, in a store which cannot create it.
+type missingReference struct{}
+
+func (missingReference) Read(ctx context.Context) (string, error) {
+	return "", secretreference.ErrNotFound
+}
+
+func (missingReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	return secretreference.ErrNotFound
+}
+
+func TestCreateSecretRequiresStoreSupportingIt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	ref, err := secretreference.WithFormat(missingReference{}, url.Values{"format": {"json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := CreateTokenCommand{
+		Url:          server.URL,
+		Token:        ref,
+		Project:      "42",
+		Name:         "deploy",
+		Duration:     30 * 24 * time.Hour,
+		CreateSecret: true,
+	}
+	if err = command.Create(context.Background()); err == nil {
+		t.Fatal("expected an error when the secret store cannot create the secret")
+	}
+}
+
+func TestCreateCopiesToken(t *testing.T) {
+	server := newFakeGitlab(t)
+	t.Setenv("SEED_TOKEN", "")
+
+	command := CreateTokenCommand{
+		Url:      server.URL,
+		Token:    newMemReference(t, "mem://project-token?env=SEED_TOKEN"),
+		Project:  "42",
+		Name:     "deploy",
+		Duration: 30 * 24 * time.Hour,
+		CopyTo:   []secretreference.SecretReference{newMemReference(t, "mem://lambda")},
+	}
+	if err := command.Create(context.Background()); err == nil {
+		t.Fatal("expected an error when a secret to copy to does not exist")
+	}
+	if updates := mem.Lookup("project-token").Updates(); len(updates) != 0 {
+		t.Fatalf("expected no token to be created, got %v", updates)
+	}
+
+	command.CreateSecret = true
+	if err := command.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"project-token", "lambda"} {
+		if updates := mem.Lookup(name).Updates(); len(updates) != 1 || updates[0].Token != "glpat-new" {
+			t.Errorf("expected the new token to be stored in %s, got %v", name, updates)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package gitlab
+
+import (
+	"log"
+	"net/url"
+
+	"github.com/xanzy/go-gitlab"
+
+	"token-manager/internal/secretreference"
+)
+
+// setPayload sets the details of the token to write, if the secret holds a json payload. The details are
+// read from Gitlab using the token itself. If they cannot be read, only the token and its expiry are written.
+func setPayload(ref secretreference.SecretReference, baseURL, token string) {
+	if !secretreference.StoresPayload(ref) {
+		return
+	}
+
+	payload := secretreference.Payload{Host: baseURL}
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		payload.Host = u.Host
+	}
+
+	client, err := gitlab.NewClient(token, gitlab.WithBaseURL(baseURL))
+	if err != nil {
+		log.Printf("failed to read the details of the token for %s, %s", ref, err)
+		return
+	}
+	accessToken, _, err := client.PersonalAccessTokens.GetSinglePersonalAccessToken()
+	if err != nil {
+		log.Printf("failed to read the details of the token for %s, %s", ref, err)
+		return
+	}
+	payload.ID = accessToken.ID
+	payload.Name = accessToken.Name
+	payload.Scopes = accessToken.Scopes
+
+	if user, _, err := client.Users.CurrentUser(); err == nil {
+		payload.User = user.Username
+	} else {
+		log.Printf("failed to read the user of api token %s, %s", accessToken.Name, err)
+	}
+	secretreference.SetPayload(ref, payload)
+}
@@ -0,0 +1,89 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+
+	"token-manager/internal/secretreference"
+)
+
+type RollbackCommand struct {
+	Url       string
+	Token     secretreference.SecretReference
+	ToVersion string
+}
+
+// Rollback restores an earlier version of the token in the secret store. Without a version, the version
+// before the current one is restored. The token must still be a valid Gitlab token.
+func (c RollbackCommand) Rollback(ctx context.Context) error {
+	versions, err := secretreference.Versions(ctx, c.Token)
+	if err != nil {
+		return err
+	}
+
+	version, err := c.selectVersion(versions)
+	if err != nil {
+		return err
+	}
+
+	token, err := secretreference.ReadVersion(ctx, c.Token, version.ID)
+	if err != nil {
+		return err
+	}
+
+	tokenClient, err := gitlab.NewClient(token, gitlab.WithBaseURL(c.Url))
+	if err != nil {
+		return err
+	}
+	accessToken, _, err := tokenClient.PersonalAccessTokens.GetSinglePersonalAccessToken()
+	if err != nil {
+		return fmt.Errorf("version %s of %s is not a valid gitlab token, %w", version.ID, c.Token, err)
+	}
+	if accessToken.Revoked || !accessToken.Active {
+		return fmt.Errorf("version %s of %s is api token %s (id %d), which is no longer active",
+			version.ID, c.Token, accessToken.Name, accessToken.ID)
+	}
+
+	var expiresAt time.Time
+	if accessToken.ExpiresAt != nil {
+		expiresAt = time.Time(*accessToken.ExpiresAt)
+	}
+	log.Printf("version %s of %s is api token %s (id %d), which will expire on %s",
+		version.ID, c.Token, accessToken.Name, accessToken.ID, expiresAt.Format(time.DateOnly))
+
+	setPayload(c.Token, c.Url, token)
+	if err = c.Token.Update(ctx, token, expiresAt); err != nil {
+		return err
+	}
+
+	log.Printf("restored api token %s from version %s", accessToken.Name, version.ID)
+	return nil
+}
+
+// selectVersion returns the version to restore, which is ToVersion or the version before the current one.
+func (c RollbackCommand) selectVersion(versions []secretreference.Version) (secretreference.Version, error) {
+	ids := make([]string, 0, len(versions))
+	for i, version := range versions {
+		if c.ToVersion == "" && version.Current && i+1 < len(versions) {
+			return versions[i+1], nil
+		}
+		if c.ToVersion != "" && version.ID == c.ToVersion {
+			if version.Current {
+				return version, fmt.Errorf("version %s is the current version of %s", version.ID, c.Token)
+			}
+			return version, nil
+		}
+		ids = append(ids, version.ID)
+	}
+
+	if c.ToVersion == "" {
+		return secretreference.Version{}, fmt.Errorf("no previous version of %s found", c.Token)
+	}
+	return secretreference.Version{}, fmt.Errorf("version %s of %s not found, available versions: %s",
+		c.ToVersion, c.Token, strings.Join(ids, ", "))
+}
@@ -0,0 +1,38 @@
+package gitlab
+
+import (
+	"context"
+	"testing"
+
+	"token-manager/internal/secretreference/mem"
+)
+
+func TestRollback(t *testing.T) {
+	server := newFakeGitlab(t)
+	ref := newMemReference(t, "mem://rollback")
+	secret := mem.Lookup("rollback")
+	for _, token := range []string{"glpat-old", "glpat-new", "glpat-revoked"} {
+		secret.Set(token)
+	}
+
+	command := RollbackCommand{Url: server.URL, Token: ref}
+	if err := command.Rollback(context.Background()); err == nil {
+		t.Errorf("expected an error restoring the invalid previous token")
+	}
+
+	command.ToVersion = "1"
+	if err := command.Rollback(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := ref.Read(context.Background()); token != "glpat-old" {
+		t.Errorf("expected version 1 to be restored, got %s", token)
+	}
+	if updates := secret.Updates(); len(updates) != 1 || updates[0].ExpiresAt.Format("2006-01-02") != "2024-05-01" {
+		t.Errorf("expected the expiry date of the restored token, got %v", updates)
+	}
+
+	command.ToVersion = "42"
+	if err := command.Rollback(context.Background()); err == nil {
+		t.Errorf("expected an error for a missing version")
+	}
+}
@@ -2,32 +2,41 @@ package gitlab
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"slices"
 	"time"
 
 	"github.com/xanzy/go-gitlab"
 
+	"token-manager/internal/rescue"
 	"token-manager/internal/secretreference"
 )
 
 type GitlabRotateCommand struct {
-	Url        string
-	Token      secretreference.SecretReference
-	AdminToken secretreference.SecretReference
-	Project    string
-	Group      string
-	Duration   time.Duration
+	Url          string
+	Token        secretreference.SecretReference
+	AdminToken   secretreference.SecretReference
+	Project      string
+	Group        string
+	Duration     time.Duration
+	KeepVersions int
+	CopyTo       []secretreference.SecretReference
 }
 
 func (c GitlabRotateCommand) Rotate(ctx context.Context) error {
 	var err error
-	var token string
+	var token, version string
 	var tokenClient, adminClient *gitlab.Client
 
-	token, err = c.Token.Read(ctx)
+	if secretreference.IsWriteOnly(c.Token) {
+		return fmt.Errorf("the token in %s cannot be rotated, as it cannot be read from a %s", c.Token, secretreference.ErrWriteOnly)
+	}
+
+	token, version, err = secretreference.ReadWithVersion(ctx, c.Token)
 	if err != nil {
 		return err
 	}
@@ -70,20 +79,77 @@ func (c GitlabRotateCommand) Rotate(ctx context.Context) error {
 		return err
 	}
 
-	err = c.Token.Update(ctx, newToken, newExpirationDate)
+	setPayload(c.Token, c.Url, newToken)
+	err = secretreference.UpdateIfVersion(ctx, c.Token, newToken, newExpirationDate, version)
+	if errors.Is(err, secretreference.ErrConflict) {
+		return c.concurrentlyRotated(ctx, tokenName, newToken)
+	}
 	if err != nil {
 		log.Printf("Error updating the gitlab access token in 1password. Manual renewal and update to %s is required",
 			c.Token)
-		writeTokenToTemporaryFile(newToken)
-		return err
+	}
+
+	copyErr := copyToken(ctx, c.CopyTo, nil, c.Url, newToken, newExpirationDate)
+	if err != nil || copyErr != nil {
+		rescue.WriteToken("gl-token-", newToken)
+		return errors.Join(err, copyErr)
 	}
 
 	log.Printf("rotated api token %s, will expire on %s",
 		tokenName, newExpirationDate.Format(time.DateOnly))
 
+	if err = secretreference.Prune(ctx, c.Token, c.KeepVersions); err != nil {
+		log.Printf("failed to remove superseded versions of %s, %s", c.Token, err)
+	}
+
 	return nil
 }
 
+// concurrentlyRotated handles a lost race with another rotation of the same token. If the token
+// written by the winner is valid, the token of this rotation is revoked. Otherwise it is rescued.
+func (c GitlabRotateCommand) concurrentlyRotated(ctx context.Context, tokenName, newToken string) error {
+	log.Printf("%s was updated concurrently by another rotation", c.Token)
+
+	winner, err := c.Token.Read(ctx)
+	if err == nil {
+		var client *gitlab.Client
+		if client, err = gitlab.NewClient(winner, gitlab.WithBaseURL(c.Url)); err == nil {
+			var accessToken *gitlab.PersonalAccessToken
+			accessToken, _, err = client.PersonalAccessTokens.GetSinglePersonalAccessToken()
+			if err == nil && accessToken.Active && !accessToken.Revoked {
+				log.Printf("api token %s was rotated concurrently to id %d, will expire on %s. The token of this rotation is revoked",
+					accessToken.Name, accessToken.ID, accessToken.ExpiresAt.String())
+				if err = c.revokeSelf(newToken); err != nil {
+					log.Printf("failed to revoke the token of this rotation, %s", err)
+				}
+				return nil
+			}
+		}
+	}
+
+	log.Printf("the token in %s written by the other rotation is not valid. Manual update of api token %s to %s is required",
+		c.Token, tokenName, c.Token)
+	rescue.WriteToken("gl-token-", newToken)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("the token in %s is not active, %w", c.Token, secretreference.ErrConflict)
+}
+
+// revokeSelf revokes the token, using the token itself.
+func (c GitlabRotateCommand) revokeSelf(token string) error {
+	client, err := gitlab.NewClient(token, gitlab.WithBaseURL(c.Url))
+	if err != nil {
+		return err
+	}
+	request, err := client.NewRequest(http.MethodDelete, "personal_access_tokens/self", nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request, nil)
+	return err
+}
+
 func (c GitlabRotateCommand) ExpirationDate() *gitlab.ISOTime {
 	newExpirationDate := time.Now().Add(c.Duration).Truncate(time.Hour * 24)
 	return (*gitlab.ISOTime)(&newExpirationDate)
@@ -126,23 +192,3 @@ func (c GitlabRotateCommand) rotateGroupAccessToken(client *gitlab.Client, token
 	}
 	return newAccessToken.Name, newAccessToken.Token, time.Time(*newAccessToken.ExpiresAt), nil
 }
-
-// writeTokenToTemporaryFile writes token to temporary file to recover from a failed attempt to
-// write to the token store.
-func writeTokenToTemporaryFile(token string) {
-	file, err := os.CreateTemp("/tmp", "gl-token-")
-	if err != nil {
-		log.Printf("failed to create temporary file to store token, %s", err.Error())
-		return
-	}
-	defer file.Close()
-	if err = os.Chmod(file.Name(), 0o600); err != nil {
-		log.Printf("failed to chmod %s, %s", file.Name(), err.Error())
-	}
-
-	if err = os.WriteFile(file.Name(), []byte(token), 0o600); err != nil {
-		log.Printf("failed to write token to temporary file, %s", err.Error())
-		return
-	}
-	log.Printf("token written to %s", file.Name())
-}
@@ -0,0 +1,305 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"token-manager/internal/rescue"
+	"token-manager/internal/secretreference"
+	"token-manager/internal/secretreference/mem"
+)
+
+// newFakeGitlab creates a stand-in for the GitLab access token API, which issues glpat-new tokens.
+func newFakeGitlab(t *testing.T) *httptest.Server {
+	reply := func(w http.ResponseWriter, value any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(value)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/personal_access_tokens/self", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Private-Token") {
+		case "glpat-old":
+			reply(w, map[string]any{"id": 1, "name": "bot", "active": true, "scopes": []string{"api"}, "expires_at": "2024-05-01"})
+		case "glpat-winner":
+			reply(w, map[string]any{"id": 5, "name": "bot", "active": true, "scopes": []string{"api"}, "expires_at": "2024-06-01"})
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("DELETE /api/v4/personal_access_tokens/self", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Private-Token") != "glpat-new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]any{"id": 7, "username": "project_42_bot"})
+	})
+	mux.HandleFunc("POST /api/v4/personal_access_tokens/1/rotate", func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		reply(w, map[string]any{"id": 2, "name": "bot", "token": "glpat-new", "expires_at": request["expires_at"]})
+	})
+	mux.HandleFunc("GET /api/v4/projects/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, []any{map[string]any{"id": 3, "name": "existing"}})
+	})
+	mux.HandleFunc("POST /api/v4/projects/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		reply(w, map[string]any{"id": 4, "name": request["name"], "token": "glpat-new", "expires_at": request["expires_at"]})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newMemReference creates an in-memory secret reference for the test.
+func newMemReference(t *testing.T, referenceURL string) secretreference.SecretReference {
+	t.Cleanup(mem.Reset)
+	u, _ := url.Parse(referenceURL)
+	ref, err := mem.NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+// rescueFiles redirects rescue files to a temporary directory and returns a function listing them.
+func rescueFiles(t *testing.T) func() []string {
+	rescue.Directory = t.TempDir()
+	t.Cleanup(func() { rescue.Directory = "/tmp" })
+	return func() []string {
+		files, _ := filepath.Glob(filepath.Join(rescue.Directory, "gl-token-*"))
+		return files
+	}
+}
+
+func TestRotate(t *testing.T) {
+	server := newFakeGitlab(t)
+	files := rescueFiles(t)
+	t.Setenv("SEED_TOKEN", "glpat-old")
+
+	command := GitlabRotateCommand{
+		Url:      server.URL,
+		Token:    newMemReference(t, "mem://pat?env=SEED_TOKEN"),
+		Duration: 30 * 24 * time.Hour,
+	}
+	if err := command.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := mem.Lookup("pat").Updates()
+	if len(updates) != 1 || updates[0].Token != "glpat-new" {
+		t.Fatalf("expected the new token to be stored, got %v", updates)
+	}
+	if expected := time.Time(*command.ExpirationDate()); !updates[0].ExpiresAt.Equal(expected) {
+		t.Errorf("expected expiry %s, got %s", expected, updates[0].ExpiresAt)
+	}
+	if len(files()) != 0 {
+		t.Errorf("unexpected rescue files %v", files())
+	}
+}
+
+func TestRotateWritesPayload(t *testing.T) {
+	server := newFakeGitlab(t)
+	t.Setenv("SEED_TOKEN", "glpat-old")
+
+	// the details of the rotated token are read using the rotated token
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v4/personal_access_tokens/self" && r.Header.Get("Private-Token") == "glpat-new" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id": 2, "name": "bot", "active": true, "scopes": ["api"], "expires_at": "2024-06-01"}`))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	ref, err := secretreference.WithFormat(newMemReference(t, "mem://pat?env=SEED_TOKEN"), url.Values{"format": {"json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := GitlabRotateCommand{
+		Url:      server.URL,
+		Token:    ref,
+		Duration: 30 * 24 * time.Hour,
+	}
+	if err = command.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := mem.Lookup("pat").Updates()
+	if len(updates) != 1 {
+		t.Fatalf("expected a single update, got %v", updates)
+	}
+	var payload secretreference.Payload
+	if err = json.Unmarshal([]byte(updates[0].Token), &payload); err != nil {
+		t.Fatalf("expected a json payload, got %s", updates[0].Token)
+	}
+	host, _ := url.Parse(server.URL)
+	if payload.Token != "glpat-new" || payload.ID != 2 || payload.User != "project_42_bot" || payload.Host != host.Host ||
+		payload.ExpiresAt != updates[0].ExpiresAt.Format(time.DateOnly) {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+
+	if user, err := secretreference.ReadPayload(context.Background(), ref); err != nil || user.User != "project_42_bot" {
+		t.Errorf("ReadPayload() = %+v, %v", user, err)
+	}
+}
+
+func TestRotateKeepsVersions(t *testing.T) {
+	server := newFakeGitlab(t)
+	ref := newMemReference(t, "mem://pat")
+	for _, token := range []string{"glpat-older", "glpat-old"} {
+		mem.Lookup("pat").Set(token)
+	}
+
+	command := GitlabRotateCommand{
+		Url:          server.URL,
+		Token:        ref,
+		Duration:     30 * 24 * time.Hour,
+		KeepVersions: 2,
+	}
+	if err := command.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := secretreference.Versions(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].ID != "3" || versions[1].ID != "2" {
+		t.Errorf("expected the two newest versions to be kept, got %v", versions)
+	}
+}
+
+func TestRotateRescuesTokenOnFailedUpdate(t *testing.T) {
+	server := newFakeGitlab(t)
+	files := rescueFiles(t)
+	t.Setenv("SEED_TOKEN", "glpat-old")
+
+	command := GitlabRotateCommand{
+		Url:      server.URL,
+		Token:    newMemReference(t, "mem://pat?env=SEED_TOKEN&fail-update=1"),
+		Duration: 30 * 24 * time.Hour,
+	}
+	if err := command.Rotate(context.Background()); err == nil {
+		t.Fatal("expected the failed update to be returned")
+	}
+
+	rescued := files()
+	if len(rescued) != 1 {
+		t.Fatalf("expected a single rescue file, got %v", rescued)
+	}
+	if content, _ := os.ReadFile(rescued[0]); string(content) != "glpat-new" {
+		t.Errorf("expected the new token in the rescue file, got %s", content)
+	}
+}
+
+func TestRotateLosesRace(t *testing.T) {
+	server := newFakeGitlab(t)
+	files := rescueFiles(t)
+	t.Setenv("SEED_TOKEN", "glpat-old")
+
+	// another rotation stores its token, while this rotation is waiting for GitLab
+	var revoked []string
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			mem.Lookup("pat").Set("glpat-winner")
+		case http.MethodDelete:
+			revoked = append(revoked, r.Header.Get("Private-Token"))
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	command := GitlabRotateCommand{
+		Url:      server.URL,
+		Token:    newMemReference(t, "mem://pat?env=SEED_TOKEN"),
+		Duration: 30 * 24 * time.Hour,
+	}
+	if err := command.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, _ := command.Token.Read(context.Background()); token != "glpat-winner" {
+		t.Errorf("expected the token of the winner to be kept, got %s", token)
+	}
+	if updates := mem.Lookup("pat").Updates(); len(updates) != 0 {
+		t.Errorf("expected no updates by the losing rotation, got %v", updates)
+	}
+	if len(files()) != 0 {
+		t.Errorf("unexpected rescue files %v", files())
+	}
+	if len(revoked) != 1 || revoked[0] != "glpat-new" {
+		t.Errorf("expected the token of the losing rotation to revoke itself, got %v", revoked)
+	}
+}
+
+func TestRotateCopiesToken(t *testing.T) {
+	server := newFakeGitlab(t)
+	files := rescueFiles(t)
+	t.Setenv("SEED_TOKEN", "glpat-old")
+
+	command := GitlabRotateCommand{
+		Url:      server.URL,
+		Token:    newMemReference(t, "mem://pat?env=SEED_TOKEN"),
+		Duration: 30 * 24 * time.Hour,
+		CopyTo: []secretreference.SecretReference{
+			newMemReference(t, "mem://lambda"),
+			newMemReference(t, "mem://pipeline"),
+		},
+	}
+	if err := command.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pat", "lambda", "pipeline"} {
+		if updates := mem.Lookup(name).Updates(); len(updates) != 1 || updates[0].Token != "glpat-new" {
+			t.Errorf("expected the new token to be stored in %s, got %v", name, updates)
+		}
+	}
+	if len(files()) != 0 {
+		t.Errorf("unexpected rescue files %v", files())
+	}
+}
+
+func TestRotateRescuesTokenOnFailedCopy(t *testing.T) {
+	server := newFakeGitlab(t)
+	files := rescueFiles(t)
+	t.Setenv("SEED_TOKEN", "glpat-old")
+
+	command := GitlabRotateCommand{
+		Url:      server.URL,
+		Token:    newMemReference(t, "mem://pat?env=SEED_TOKEN"),
+		Duration: 30 * 24 * time.Hour,
+		CopyTo: []secretreference.SecretReference{
+			newMemReference(t, "mem://lambda?fail-update=1"),
+			newMemReference(t, "mem://pipeline"),
+		},
+	}
+	if err := command.Rotate(context.Background()); err == nil {
+		t.Fatal("expected the failed copy to be returned")
+	}
+
+	for _, name := range []string{"pat", "pipeline"} {
+		if updates := mem.Lookup(name).Updates(); len(updates) != 1 || updates[0].Token != "glpat-new" {
+			t.Errorf("expected the new token to be stored in %s, got %v", name, updates)
+		}
+	}
+	rescued := files()
+	if len(rescued) != 1 {
+		t.Fatalf("expected a single rescue file, got %v", rescued)
+	}
+	if content, _ := os.ReadFile(rescued[0]); string(content) != "glpat-new" {
+		t.Errorf("expected the new token in the rescue file, got %s", content)
+	}
+}
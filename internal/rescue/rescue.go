@@ -0,0 +1,82 @@
+package rescue
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// RecipientsEnvironmentVariable contains the age recipients to encrypt rescued tokens for.
+const RecipientsEnvironmentVariable = "TOKEN_MANAGER_RESCUE_RECIPIENTS"
+
+// Directory is the directory in which rescue files are written.
+var Directory = "/tmp"
+
+// stderr receives the token, if it cannot be written to a file encrypted as configured.
+var stderr io.Writer = os.Stderr
+
+// WriteToken writes token to temporary file to recover from a failed attempt to
+// write to the token store. If age recipients are configured, the token is encrypted.
+// If the recipients are invalid, no file is written and the token is only printed to stderr.
+func WriteToken(pattern string, token string) {
+	recipients, err := parseRecipients(os.Getenv(RecipientsEnvironmentVariable))
+	if err != nil {
+		log.Printf("invalid age recipients in %s, not writing the token to a file unencrypted, %s", RecipientsEnvironmentVariable, err)
+		fmt.Fprintf(stderr, "rescued token: %s\n", token)
+		return
+	}
+	if len(recipients) > 0 {
+		pattern = pattern + "*.age"
+	}
+
+	file, err := os.CreateTemp(Directory, pattern)
+	if err != nil {
+		log.Printf("failed to create temporary file to store token, %s", err.Error())
+		return
+	}
+	defer file.Close()
+	if err = os.Chmod(file.Name(), 0o600); err != nil {
+		log.Printf("failed to chmod %s, %s", file.Name(), err.Error())
+	}
+
+	if len(recipients) > 0 {
+		err = encrypt(file, token, recipients)
+	} else {
+		_, err = file.WriteString(token)
+	}
+	if err != nil {
+		log.Printf("failed to write token to temporary file, %s", err.Error())
+		return
+	}
+	log.Printf("token written to %s", file.Name())
+}
+
+// parseRecipients parses the comma or whitespace separated age recipients.
+func parseRecipients(value string) ([]age.Recipient, error) {
+	recipients := strings.Fields(strings.ReplaceAll(value, ",", " "))
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+	return age.ParseRecipients(strings.NewReader(strings.Join(recipients, "\n")))
+}
+
+// encrypt writes the token encrypted for the recipients in the ASCII armored age format.
+func encrypt(w io.Writer, token string, recipients []age.Recipient) error {
+	armored := armor.NewWriter(w)
+	encrypted, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(encrypted, token); err != nil {
+		return err
+	}
+	if err = encrypted.Close(); err != nil {
+		return err
+	}
+	return armored.Close()
+}
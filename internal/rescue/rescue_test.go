@@ -0,0 +1,70 @@
+package rescue
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func TestWriteTokenEncrypted(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(RecipientsEnvironmentVariable, identity.Recipient().String())
+	Directory = t.TempDir()
+	defer func() { Directory = "/tmp" }()
+
+	WriteToken("gl-token-", "glpat-rescued")
+
+	files, _ := filepath.Glob(filepath.Join(Directory, "gl-token-*.age"))
+	if len(files) != 1 {
+		t.Fatalf("expected a single rescue file, got %v", files)
+	}
+
+	if info, _ := os.Stat(files[0]); info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	file, _ := os.Open(files[0])
+	defer file.Close()
+	decrypted, err := age.Decrypt(armor.NewReader(file), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := io.ReadAll(decrypted); string(token) != "glpat-rescued" {
+		t.Errorf("expected the rescued token, got %s", token)
+	}
+}
+
+func TestWriteTokenWithInvalidRecipients(t *testing.T) {
+	t.Setenv(RecipientsEnvironmentVariable, "not-a-recipient")
+	Directory = t.TempDir()
+	defer func() { Directory = "/tmp" }()
+	var output strings.Builder
+	stderr = &output
+	defer func() { stderr = os.Stderr }()
+
+	WriteToken("gl-token-", "glpat-rescued")
+
+	if files, _ := filepath.Glob(filepath.Join(Directory, "gl-token-*")); len(files) != 0 {
+		t.Errorf("expected no rescue file with invalid recipients, got %v", files)
+	}
+	if !strings.Contains(output.String(), "glpat-rescued") {
+		t.Errorf("expected the token on stderr, got %s", output.String())
+	}
+}
+
+func TestParseRecipients(t *testing.T) {
+	if recipients, err := parseRecipients(" "); recipients != nil || err != nil {
+		t.Errorf("expected no recipients, got %v, %v", recipients, err)
+	}
+	if _, err := parseRecipients("not-a-recipient"); err == nil {
+		t.Errorf("expected an error for an invalid recipient")
+	}
+}
@@ -6,10 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net/url"
-	"os"
 	"slices"
 	"time"
 
+	"token-manager/internal/rescue"
 	"token-manager/internal/secretreference"
 
 	"github.com/xanzy/go-gitlab"
@@ -127,7 +127,7 @@ func (r Rotator) Rotate(ctx context.Context, project, group string, tokenReferen
 	if err != nil {
 		log.Printf("Error updating the gitlab access token in 1password. Manual renewal is required")
 		if r.rescueToken {
-			writeTokenToTemporaryFile(newToken)
+			rescue.WriteToken("token-", newToken)
 		}
 		return err
 	}
@@ -137,23 +137,3 @@ func (r Rotator) Rotate(ctx context.Context, project, group string, tokenReferen
 
 	return nil
 }
-
-// writeTokenToTemporaryFile writes token to temporary file to recover from a failed attempt to
-// write to the token store.
-func writeTokenToTemporaryFile(token string) {
-	file, err := os.CreateTemp("/tmp", "token-")
-	if err != nil {
-		log.Printf("failed to create temporary file to store token, %s", err.Error())
-		return
-	}
-	defer file.Close()
-	if err = os.Chmod(file.Name(), 0o600); err != nil {
-		log.Printf("failed to chmod %s, %s", file.Name(), err.Error())
-	}
-
-	if err = os.WriteFile(file.Name(), []byte(token), 0o600); err != nil {
-		log.Printf("failed to write token to temporary file, %s", err.Error())
-		return
-	}
-	log.Printf("token written to %s", file.Name())
-}
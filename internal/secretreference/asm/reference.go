@@ -0,0 +1,244 @@
+package asm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+const (
+	currentStage = "AWSCURRENT"
+	pendingStage = "AWSPENDING"
+)
+
+// ExpiresAtTag is the tag on the secret holding the expiry date of the current token.
+const ExpiresAtTag = "token-manager:expires-at"
+
+type TokenReference struct {
+	secretId  string
+	awsRegion string
+	client    *secretsmanager.Client
+}
+
+func (t TokenReference) String() string {
+	if strings.HasPrefix(t.secretId, "arn:") {
+		return t.secretId
+	}
+	return fmt.Sprintf("asm:///%s", t.secretId)
+}
+
+// NewTokenReference create a new AWS Secrets Manager secret token reference.
+func NewTokenReference(ctx context.Context, secretId string, awsRegion string) (*TokenReference, error) {
+	ref := TokenReference{
+		secretId: secretId, awsRegion: awsRegion,
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ref.client = secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+		if awsRegion != "" {
+			o.Region = awsRegion
+		}
+	})
+	return &ref, nil
+}
+
+var secretPattern = regexp.MustCompile(`^(?P<Partition>[^:]*):secretsmanager:(?P<Region>[^:]*):(?P<AccountID>[^:]*):secret:(?P<Resource>.+)$`)
+
+// IsSecretARN returns true if the opaque part of an arn: URL references a Secrets Manager secret.
+func IsSecretARN(opaque string) bool {
+	return secretPattern.MatchString(opaque)
+}
+
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	if referenceURL.Scheme == "arn" {
+		match := secretPattern.FindStringSubmatch(referenceURL.Opaque)
+		if match == nil {
+			return nil, fmt.Errorf("unsupported ARN arn:%s", referenceURL.Opaque)
+		}
+		return NewTokenReference(ctx, "arn:"+referenceURL.Opaque, match[secretPattern.SubexpIndex("Region")])
+	}
+
+	if referenceURL.Scheme == "asm" {
+		if referenceURL.Host != "" || len(referenceURL.Path) < 2 {
+			return nil, fmt.Errorf("expected an url in the form asm:///<secret name>")
+		}
+		return NewTokenReference(ctx, referenceURL.Path[1:], "")
+	}
+
+	return nil, fmt.Errorf("unsupported URL %s", referenceURL)
+}
+
+// Read reads the current version of the token from the secret
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	token, _, err := t.ReadWithVersion(ctx)
+	return token, err
+}
+
+// ReadWithVersion reads the current version of the token from the secret, and its version id.
+func (t TokenReference) ReadWithVersion(ctx context.Context) (string, string, error) {
+	response, err := t.client.GetSecretValue(ctx,
+		&secretsmanager.GetSecretValueInput{
+			SecretId:     aws.String(t.secretId),
+			VersionStage: aws.String(currentStage),
+		})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return "", "", fmt.Errorf("%s, %w", err, secretreference.ErrNotFound)
+	} else if err != nil {
+		return "", "", err
+	}
+	return aws.ToString(response.SecretString), aws.ToString(response.VersionId), nil
+}
+
+// Update stores the token as a new AWSCURRENT version of the secret. Secrets Manager moves the
+// AWSPREVIOUS label to the replaced version, so a bad rotation can be rolled back. The secret is
+// tagged with the expiry date on a best effort basis, a failure is logged.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	return t.UpdateIfVersion(ctx, token, expiresAt, "")
+}
+
+// UpdateIfVersion stores the token like Update, if the version id is still AWSCURRENT. The token is
+// stored as AWSPENDING first, and the AWSCURRENT label is moved from the observed version to it.
+// Secrets Manager refuses the move if another writer took the label in the meantime.
+func (t TokenReference) UpdateIfVersion(ctx context.Context, token string, expiresAt time.Time, version string) error {
+	stage := currentStage
+	if version != "" {
+		stage = pendingStage
+	}
+	response, err := t.client.PutSecretValue(ctx,
+		&secretsmanager.PutSecretValueInput{
+			SecretId:      aws.String(t.secretId),
+			SecretString:  aws.String(token),
+			VersionStages: []string{stage},
+		})
+	if err != nil {
+		return err
+	}
+
+	if version != "" {
+		_, err = t.client.UpdateSecretVersionStage(ctx,
+			&secretsmanager.UpdateSecretVersionStageInput{
+				SecretId:            aws.String(t.secretId),
+				VersionStage:        aws.String(currentStage),
+				MoveToVersionId:     response.VersionId,
+				RemoveFromVersionId: aws.String(version),
+			})
+		var invalidParameter *types.InvalidParameterException
+		if errors.As(err, &invalidParameter) {
+			return fmt.Errorf("%s, %w", err, secretreference.ErrConflict)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// the token is stored, so a missing permission to tag the secret must not fail the update
+	_, err = t.client.TagResource(ctx,
+		&secretsmanager.TagResourceInput{
+			SecretId: aws.String(t.secretId),
+			Tags:     []types.Tag{{Key: aws.String(ExpiresAtTag), Value: aws.String(expiresAt.Format(time.RFC3339))}},
+		})
+	if err != nil {
+		log.Printf("failed to tag %s with the expiry date, %s", t, err)
+	}
+	return nil
+}
+
+// Create creates the secret holding the token, tagged with the expiry date. A secret cannot be
+// created from an ARN, as Secrets Manager appends a random suffix to the name in the ARN of a new secret.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	if strings.HasPrefix(t.secretId, "arn:") {
+		return fmt.Errorf("creating %s is %w, the ARN of a secret is only known once it is created, use asm:///<secret name>",
+			t, secretreference.ErrUnsupported)
+	}
+	_, err := t.client.CreateSecret(ctx,
+		&secretsmanager.CreateSecretInput{
+			Name:         aws.String(t.secretId),
+			SecretString: aws.String(token),
+			Description:  aws.String("Gitlab token managed by token-manager"),
+			Tags:         []types.Tag{{Key: aws.String(ExpiresAtTag), Value: aws.String(expiresAt.Format(time.RFC3339))}},
+		})
+	return err
+}
+
+// Versions returns the versions of the secret, newest first. Secrets Manager only keeps the
+// versions with a staging label, and deprecates the others.
+func (t TokenReference) Versions(ctx context.Context) ([]secretreference.Version, error) {
+	var versions []secretreference.Version
+	paginator := secretsmanager.NewListSecretVersionIdsPaginator(t.client,
+		&secretsmanager.ListSecretVersionIdsInput{
+			SecretId: aws.String(t.secretId),
+		})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range page.Versions {
+			versions = append(versions, secretreference.Version{
+				ID:        aws.ToString(version.VersionId),
+				CreatedAt: aws.ToTime(version.CreatedDate),
+				Current:   slices.Contains(version.VersionStages, currentStage),
+			})
+		}
+	}
+	slices.SortFunc(versions, func(a, b secretreference.Version) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return versions, nil
+}
+
+// ReadVersion reads the token from the version of the secret
+func (t TokenReference) ReadVersion(ctx context.Context, id string) (string, error) {
+	response, err := t.client.GetSecretValue(ctx,
+		&secretsmanager.GetSecretValueInput{
+			SecretId:  aws.String(t.secretId),
+			VersionId: aws.String(id),
+		})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(response.SecretString), nil
+}
+
+// Metadata reads the current version and modification date of the secret, and the expiry date from its tags.
+func (t TokenReference) Metadata(ctx context.Context) (secretreference.Metadata, error) {
+	var metadata secretreference.Metadata
+	response, err := t.client.DescribeSecret(ctx,
+		&secretsmanager.DescribeSecretInput{
+			SecretId: aws.String(t.secretId),
+		})
+	if err != nil {
+		return metadata, err
+	}
+
+	metadata.UpdatedAt = aws.ToTime(response.LastChangedDate)
+	for versionId, stages := range response.VersionIdsToStages {
+		if slices.Contains(stages, currentStage) {
+			metadata.Version = versionId
+		}
+	}
+	for _, tag := range response.Tags {
+		if aws.ToString(tag.Key) == ExpiresAtTag {
+			if metadata.ExpiresAt, err = time.Parse(time.RFC3339, aws.ToString(tag.Value)); err != nil {
+				return metadata, fmt.Errorf("invalid %s tag on %s: %w", ExpiresAtTag, t, err)
+			}
+		}
+	}
+	return metadata, nil
+}
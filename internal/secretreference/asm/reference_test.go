@@ -0,0 +1,326 @@
+package asm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+
+	"token-manager/internal/secretreference"
+)
+
+// fakeVersion is a version of the secret in the fake Secrets Manager.
+type fakeVersion struct {
+	id      string
+	value   string
+	stages  []string
+	created time.Time
+}
+
+// fakeSecretsManager is a stand-in for the Secrets Manager API, holding a single secret.
+type fakeSecretsManager struct {
+	t           *testing.T
+	versions    []*fakeVersion
+	tags        map[string]string
+	failTagging bool
+}
+
+// newFakeReference creates a reference to the secret gitlab/pat in a fake Secrets Manager.
+func newFakeReference(t *testing.T) (*TokenReference, *fakeSecretsManager) {
+	fake := &fakeSecretsManager{t: t, tags: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := secretsmanager.New(secretsmanager.Options{
+		Region:       "eu-central-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("access", "secret", ""),
+	})
+	return &TokenReference{secretId: "gitlab/pat", client: client}, fake
+}
+
+// put adds a version holding the value, with the stages.
+func (f *fakeSecretsManager) put(value string, stages ...string) *fakeVersion {
+	version := &fakeVersion{
+		id:      fmt.Sprintf("v%d", len(f.versions)+1),
+		value:   value,
+		created: time.Date(2024, 1, 1, 0, len(f.versions), 0, 0, time.UTC),
+	}
+	f.versions = append(f.versions, version)
+	for _, stage := range stages {
+		f.moveStage(stage, version)
+	}
+	return version
+}
+
+// moveStage moves the stage to the version. Moving AWSCURRENT labels the replaced version AWSPREVIOUS.
+func (f *fakeSecretsManager) moveStage(stage string, to *fakeVersion) {
+	for _, version := range f.versions {
+		if version != to && slices.Contains(version.stages, stage) {
+			version.stages = slices.DeleteFunc(version.stages, func(s string) bool { return s == stage })
+			if stage == currentStage {
+				f.moveStage("AWSPREVIOUS", version)
+			}
+		}
+	}
+	if !slices.Contains(to.stages, stage) {
+		to.stages = append(to.stages, stage)
+	}
+}
+
+// find returns the version with the id, or with the stage.
+func (f *fakeSecretsManager) find(id, stage string) *fakeVersion {
+	for _, version := range f.versions {
+		if (id != "" && version.id == id) || (id == "" && slices.Contains(version.stages, stage)) {
+			return version
+		}
+	}
+	return nil
+}
+
+func (f *fakeSecretsManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		SecretId            string
+		SecretString        string
+		VersionId           string
+		VersionStage        string
+		VersionStages       []string
+		MoveToVersionId     string
+		RemoveFromVersionId string
+		Tags                []struct{ Key, Value string }
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		f.t.Errorf("invalid request, %v", err)
+	}
+	reply := func(value any) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_ = json.NewEncoder(w).Encode(value)
+	}
+	fail := func(errorType, message string) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"__type": errorType, "message": message})
+	}
+	setTags := func() {
+		for _, tag := range request.Tags {
+			f.tags[tag.Key] = tag.Value
+		}
+	}
+
+	switch operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "secretsmanager."); operation {
+	case "GetSecretValue":
+		stage := request.VersionStage
+		if stage == "" {
+			stage = currentStage
+		}
+		version := f.find(request.VersionId, stage)
+		if version == nil {
+			fail("ResourceNotFoundException", "Secrets Manager can't find the specified secret.")
+			return
+		}
+		reply(map[string]any{"Name": request.SecretId, "SecretString": version.value, "VersionId": version.id, "VersionStages": version.stages})
+	case "PutSecretValue":
+		stages := request.VersionStages
+		if len(stages) == 0 {
+			stages = []string{currentStage}
+		}
+		version := f.put(request.SecretString, stages...)
+		reply(map[string]any{"Name": request.SecretId, "VersionId": version.id, "VersionStages": version.stages})
+	case "UpdateSecretVersionStage":
+		if from := f.find(request.RemoveFromVersionId, ""); request.RemoveFromVersionId != "" &&
+			(from == nil || !slices.Contains(from.stages, request.VersionStage)) {
+			fail("InvalidParameterException", "The staging label is not attached to the version to remove it from.")
+			return
+		}
+		f.moveStage(request.VersionStage, f.find(request.MoveToVersionId, ""))
+		reply(map[string]any{"Name": request.SecretId})
+	case "TagResource":
+		if f.failTagging {
+			fail("AccessDeniedException", "not authorized to perform secretsmanager:TagResource")
+			return
+		}
+		setTags()
+		reply(map[string]any{})
+	case "CreateSecret":
+		if len(f.versions) > 0 {
+			fail("ResourceExistsException", "the secret already exists")
+			return
+		}
+		version := f.put(request.SecretString, currentStage)
+		setTags()
+		reply(map[string]any{"Name": request.SecretId, "VersionId": version.id})
+	case "DescribeSecret":
+		if len(f.versions) == 0 {
+			fail("ResourceNotFoundException", "Secrets Manager can't find the specified secret.")
+			return
+		}
+		stages := make(map[string][]string)
+		for _, version := range f.versions {
+			stages[version.id] = version.stages
+		}
+		tags := make([]map[string]string, 0, len(f.tags))
+		for key, value := range f.tags {
+			tags = append(tags, map[string]string{"Key": key, "Value": value})
+		}
+		reply(map[string]any{
+			"Name":               request.SecretId,
+			"LastChangedDate":    f.versions[len(f.versions)-1].created.Unix(),
+			"VersionIdsToStages": stages,
+			"Tags":               tags,
+		})
+	case "ListSecretVersionIds":
+		versions := make([]map[string]any, 0, len(f.versions))
+		for _, version := range f.versions {
+			versions = append(versions, map[string]any{"VersionId": version.id, "VersionStages": version.stages, "CreatedDate": version.created.Unix()})
+		}
+		reply(map[string]any{"Name": request.SecretId, "Versions": versions})
+	default:
+		f.t.Errorf("unexpected operation %s", operation)
+		fail("InvalidAction", operation)
+	}
+}
+
+func TestReadAndUpdate(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	old := fake.put("glpat-old", currentStage)
+
+	if token, err := ref.Read(ctx); err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ref.Read(ctx); err != nil || token != "glpat-new" {
+		t.Errorf("Read() after Update() = %s, %v", token, err)
+	}
+	if !slices.Contains(old.stages, "AWSPREVIOUS") {
+		t.Errorf("expected the replaced version to be AWSPREVIOUS, got %v", old.stages)
+	}
+
+	metadata, err := ref.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.ExpiresAt.Equal(expiresAt) || metadata.Version != "v2" {
+		t.Errorf("Metadata() = %+v", metadata)
+	}
+
+	versions, err := ref.Versions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].ID != "v2" || !versions[0].Current || versions[1].ID != "v1" || versions[1].Current {
+		t.Errorf("Versions() = %+v", versions)
+	}
+	if token, err := ref.ReadVersion(ctx, "v1"); err != nil || token != "glpat-old" {
+		t.Errorf("ReadVersion() = %s, %v", token, err)
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	fake.put("glpat-old", currentStage)
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	_, version, err := ref.ReadWithVersion(ctx)
+	if err != nil || version != "v1" {
+		t.Fatalf("ReadWithVersion() = %s, %v", version, err)
+	}
+
+	// another rotation writes its token, after this one read the secret
+	if err = ref.Update(ctx, "glpat-winner", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err = ref.UpdateIfVersion(ctx, "glpat-loser", expiresAt, version); !errors.Is(err, secretreference.ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if token, _ := ref.Read(ctx); token != "glpat-winner" {
+		t.Errorf("expected the token of the winner to stay current, got %s", token)
+	}
+
+	_, version, _ = ref.ReadWithVersion(ctx)
+	if err = ref.UpdateIfVersion(ctx, "glpat-new", expiresAt, version); err != nil {
+		t.Fatal(err)
+	}
+	current := fake.find("", currentStage)
+	if current == nil || current.value != "glpat-new" {
+		t.Errorf("expected the new token to be AWSCURRENT, got %+v", current)
+	}
+	if previous := fake.find("", "AWSPREVIOUS"); previous == nil || previous.value != "glpat-winner" {
+		t.Errorf("expected the replaced version to be AWSPREVIOUS, got %+v", previous)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := ref.Read(ctx); !errors.Is(err, secretreference.ErrNotFound) {
+		t.Errorf("Read() expected not found for a missing secret, got %v", err)
+	}
+
+	if err := ref.Create(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ref.Read(ctx); err != nil || token != "glpat-new" {
+		t.Errorf("Read() = %s, %v", token, err)
+	}
+	if fake.tags[ExpiresAtTag] != expiresAt.Format(time.RFC3339) {
+		t.Errorf("expected the expiry tag, got %v", fake.tags)
+	}
+	if err := ref.Create(ctx, "glpat-other", expiresAt); err == nil {
+		t.Error("expected an error when the secret already exists")
+	}
+}
+
+func TestCreateRejectsARN(t *testing.T) {
+	ref, fake := newFakeReference(t)
+	ref.secretId = "arn:aws:secretsmanager:eu-central-1:123456789012:secret:gitlab/pat-AbCdEf"
+
+	err := ref.Create(context.Background(), "glpat-new", time.Now())
+	if !errors.Is(err, secretreference.ErrUnsupported) {
+		t.Errorf("Create() expected an unsupported error for an ARN, got %v", err)
+	}
+	if len(fake.versions) != 0 {
+		t.Errorf("expected no secret to be created, got %d versions", len(fake.versions))
+	}
+}
+
+func TestUpdateIgnoresFailedTagging(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	fake.put("glpat-old", currentStage)
+	fake.failTagging = true
+
+	if err := ref.Update(ctx, "glpat-new", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("expected a failed tag not to fail the update, got %v", err)
+	}
+	if token, err := ref.Read(ctx); err != nil || token != "glpat-new" {
+		t.Errorf("Read() = %s, %v", token, err)
+	}
+}
+
+func TestMetadataRejectsInvalidExpiry(t *testing.T) {
+	ref, fake := newFakeReference(t)
+	fake.put("glpat-old", currentStage)
+	fake.tags[ExpiresAtTag] = "next week"
+
+	if _, err := ref.Metadata(context.Background()); err == nil {
+		t.Error("expected an error for an invalid expiry date tag")
+	}
+}
@@ -0,0 +1,113 @@
+package azkv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyVaultScope = "https://vault.azure.net/.default"
+
+// cloudDNSSuffixes are the DNS suffixes of vaults in the sovereign clouds. Access tokens for these
+// vaults are issued for the suffix of the cloud, and for the public cloud otherwise.
+var cloudDNSSuffixes = []string{"vault.azure.cn", "vault.usgovcloudapi.net", "vault.microsoftazure.de"}
+
+// scopeOf returns the scope of access tokens for the vault at the endpoint.
+func scopeOf(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return keyVaultScope
+	}
+	for _, suffix := range cloudDNSSuffixes {
+		if strings.HasSuffix(u.Hostname(), "."+suffix) {
+			return "https://" + suffix + "/.default"
+		}
+	}
+	return keyVaultScope
+}
+
+// credentials obtains an access token for Key Vault using the client secret from the environment
+// or the federated token of an Azure workload identity.
+type credentials struct {
+	httpClient *http.Client
+	scope      string
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// accessToken returns a cached access token, or requests a new one if it is about to expire.
+func (c *credentials) accessToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token != "" && time.Now().Add(time.Minute).Before(c.expiresAt) {
+		return c.token, nil
+	}
+
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	if tenantID == "" || clientID == "" {
+		return "", errors.New("AZURE_TENANT_ID and AZURE_CLIENT_ID are required to access Key Vault")
+	}
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {clientID},
+		"scope":      {c.scope},
+	}
+	if secret := os.Getenv("AZURE_CLIENT_SECRET"); secret != "" {
+		form.Set("client_secret", secret)
+	} else if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); tokenFile != "" {
+		assertion, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", err
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	} else {
+		return "", errors.New("AZURE_CLIENT_SECRET or AZURE_FEDERATED_TOKEN_FILE is required to access Key Vault")
+	}
+
+	authorityHost := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authorityHost == "" {
+		authorityHost = "https://login.microsoftonline.com"
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"), url.PathEscape(tenantID))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to obtain an access token for Key Vault, %s", result.ErrorDescription)
+	}
+
+	c.token = result.AccessToken
+	c.expiresAt = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return c.token, nil
+}
@@ -0,0 +1,289 @@
+package azkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+const apiVersion = "7.4"
+
+var errNotFound = fmt.Errorf("secret %w", secretreference.ErrNotFound)
+
+type TokenReference struct {
+	vaultName    string
+	secretName   string
+	endpoint     string
+	keepVersions int
+	credentials  *credentials
+}
+
+// secretBundle is the Key Vault representation of a secret.
+type secretBundle struct {
+	ID          string            `json:"id,omitempty"`
+	Value       string            `json:"value"`
+	ContentType string            `json:"contentType,omitempty"`
+	Attributes  secretAttributes  `json:"attributes"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type secretAttributes struct {
+	Enabled *bool  `json:"enabled,omitempty"`
+	Expires *int64 `json:"exp,omitempty"`
+	Updated *int64 `json:"updated,omitempty"`
+}
+
+func (t TokenReference) String() string {
+	if t.endpoint != defaultEndpoint(t.vaultName) {
+		return fmt.Sprintf("azkv://%s/%s?endpoint=%s", t.vaultName, t.secretName, url.QueryEscape(t.endpoint))
+	}
+	return fmt.Sprintf("azkv://%s/%s", t.vaultName, t.secretName)
+}
+
+func defaultEndpoint(vaultName string) string {
+	return fmt.Sprintf("https://%s.vault.azure.net", vaultName)
+}
+
+// NewTokenReference create a new Azure Key Vault secret token reference. If no endpoint is
+// specified, the public cloud endpoint of the vault is used. Access tokens are requested for the
+// cloud of the endpoint.
+func NewTokenReference(_ context.Context, vaultName, secretName, endpoint string) (*TokenReference, error) {
+	if vaultName == "" || secretName == "" {
+		return nil, errors.New("a key vault name and secret name are required")
+	}
+	if endpoint == "" {
+		endpoint = defaultEndpoint(vaultName)
+	}
+	return &TokenReference{
+		vaultName:   vaultName,
+		secretName:  secretName,
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		credentials: &credentials{httpClient: http.DefaultClient, scope: scopeOf(endpoint)},
+	}, nil
+}
+
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	// azkv://my-vault/gitlab-pat?endpoint=https://my-vault.vault.azure.cn
+	if referenceURL.Scheme != "azkv" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	secretName := strings.TrimPrefix(referenceURL.Path, "/")
+	if referenceURL.Host == "" || secretName == "" || strings.Contains(secretName, "/") {
+		return nil, errors.New("expected an url in the form azkv://<vault name>/<secret name>")
+	}
+	q, err := url.ParseQuery(referenceURL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	keepVersions, err := secretreference.ParseKeepVersions(q)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := NewTokenReference(ctx, referenceURL.Host, secretName, q.Get("endpoint"))
+	if err != nil {
+		return nil, err
+	}
+	ref.keepVersions = keepVersions
+	return ref, nil
+}
+
+// do executes a request on the path of the secret, using an access token obtained from the credentials.
+func (t TokenReference) do(ctx context.Context, method, path string, body, result any) error {
+	secretURL := fmt.Sprintf("%s/secrets/%s%s?api-version=%s", t.endpoint, url.PathEscape(t.secretName), path, apiVersion)
+	return t.request(ctx, method, secretURL, body, result)
+}
+
+// request executes a request on the URL, using an access token obtained from the credentials.
+func (t TokenReference) request(ctx context.Context, method, requestURL string, body, result any) error {
+	accessToken, err := t.credentials.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	var content bytes.Buffer
+	if body != nil {
+		if err = json.NewEncoder(&content).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, &content)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := t.credentials.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var apiError struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&apiError)
+		if response.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%s %s, %w: %s", method, t, errNotFound, apiError.Error.Message)
+		}
+		return fmt.Errorf("%s %s failed with status %d: %s", method, t, response.StatusCode, apiError.Error.Message)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// Read reads the token from the current version of the Key Vault secret
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	var secret secretBundle
+	if err := t.do(ctx, http.MethodGet, "", nil, &secret); err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+// Update adds a new version of the Key Vault secret with the token, which expires at expiresAt.
+// The content type and tags of the current version are retained. A missing secret is created.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	var current secretBundle
+	if err := t.do(ctx, http.MethodGet, "", nil, &current); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+
+	expires := expiresAt.Unix()
+	secret := secretBundle{
+		Value:       token,
+		ContentType: current.ContentType,
+		Attributes:  secretAttributes{Expires: &expires},
+		Tags:        current.Tags,
+	}
+	return t.do(ctx, http.MethodPut, "", secret, &secretBundle{})
+}
+
+// Create creates the Key Vault secret with the token, which expires at expiresAt. It fails if the
+// secret already exists.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	err := t.do(ctx, http.MethodGet, "", nil, &secretBundle{})
+	if err == nil {
+		return fmt.Errorf("secret %s already exists", t)
+	}
+	if !errors.Is(err, errNotFound) {
+		return err
+	}
+
+	expires := expiresAt.Unix()
+	secret := secretBundle{
+		Value:       token,
+		ContentType: "text/plain",
+		Attributes:  secretAttributes{Expires: &expires},
+	}
+	return t.do(ctx, http.MethodPut, "", secret, &secretBundle{})
+}
+
+// Metadata reads the expiry, update time and version of the current version of the Key Vault secret.
+func (t TokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	var secret secretBundle
+	if err = t.do(ctx, http.MethodGet, "", nil, &secret); err != nil {
+		return metadata, err
+	}
+	if secret.Attributes.Expires != nil {
+		metadata.ExpiresAt = time.Unix(*secret.Attributes.Expires, 0)
+	}
+	if secret.Attributes.Updated != nil {
+		metadata.UpdatedAt = time.Unix(*secret.Attributes.Updated, 0)
+	}
+	if i := strings.LastIndex(secret.ID, "/"); i >= 0 {
+		metadata.Version = secret.ID[i+1:]
+	}
+	return metadata, nil
+}
+
+// Versions returns the enabled versions of the Key Vault secret, newest first. Key Vault returns the
+// versions in pages, which are followed through their next link.
+func (t TokenReference) Versions(ctx context.Context) ([]secretreference.Version, error) {
+	var current secretBundle
+	if err := t.do(ctx, http.MethodGet, "", nil, &current); err != nil {
+		return nil, err
+	}
+
+	var versions []secretreference.Version
+	nextLink := fmt.Sprintf("%s/secrets/%s/versions?api-version=%s", t.endpoint, url.PathEscape(t.secretName), apiVersion)
+	for nextLink != "" {
+		if !strings.HasPrefix(nextLink, t.endpoint+"/") {
+			return nil, fmt.Errorf("unexpected next link %s in the versions of %s", nextLink, t)
+		}
+		var response struct {
+			Value []struct {
+				ID         string `json:"id"`
+				Attributes struct {
+					Enabled bool  `json:"enabled"`
+					Created int64 `json:"created"`
+				} `json:"attributes"`
+			} `json:"value"`
+			NextLink string `json:"nextLink"`
+		}
+		if err := t.request(ctx, http.MethodGet, nextLink, nil, &response); err != nil {
+			return nil, err
+		}
+		for _, version := range response.Value {
+			if !version.Attributes.Enabled {
+				continue
+			}
+			versions = append(versions, secretreference.Version{
+				ID:        version.ID[strings.LastIndex(version.ID, "/")+1:],
+				CreatedAt: time.Unix(version.Attributes.Created, 0),
+				Current:   version.ID == current.ID,
+			})
+		}
+		nextLink = response.NextLink
+	}
+	slices.SortFunc(versions, func(a, b secretreference.Version) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return versions, nil
+}
+
+// ReadVersion reads the token from the version of the Key Vault secret
+func (t TokenReference) ReadVersion(ctx context.Context, id string) (string, error) {
+	var secret secretBundle
+	if err := t.do(ctx, http.MethodGet, "/"+url.PathEscape(id), nil, &secret); err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+// KeepVersions returns the number of versions to keep, from the keep-versions query parameter.
+func (t TokenReference) KeepVersions() int {
+	return t.keepVersions
+}
+
+// Prune disables the versions of the Key Vault secret, except the newest keep versions and the current
+// one. Key Vault does not allow deleting a single version of a secret.
+func (t TokenReference) Prune(ctx context.Context, keep int) error {
+	versions, err := t.Versions(ctx)
+	if err != nil {
+		return err
+	}
+	disabled := false
+	for i, version := range versions {
+		if i < keep || version.Current {
+			continue
+		}
+		update := map[string]any{"attributes": secretAttributes{Enabled: &disabled}}
+		if err = t.do(ctx, http.MethodPatch, "/"+url.PathEscape(version.ID), update, &secretBundle{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package azkv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadAndUpdate(t *testing.T) {
+	secret := secretBundle{Value: "glpat-old", ContentType: "text/plain", Tags: map[string]string{"owner": "platform"}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "secret" || r.FormValue("scope") != keyVaultScope {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "expires_in": 3600})
+	})
+	mux.HandleFunc("/secrets/gitlab-pat", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" || r.URL.Query().Get("api-version") != apiVersion {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPut {
+			secret = secretBundle{}
+			_ = json.NewDecoder(r.Body).Decode(&secret)
+			secret.ID = "https://my-vault.vault.azure.net/secrets/gitlab-pat/4387e9f3d6e14c459867679a90fd0f79"
+		}
+		_ = json.NewEncoder(w).Encode(secret)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL)
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+	ctx := context.Background()
+	u, _ := url.Parse("azkv://my-vault/gitlab-pat?endpoint=" + url.QueryEscape(server.URL))
+	ref, err := NewFromURL(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.(*TokenReference).String() != u.String() {
+		t.Errorf("String() = %s, expected %s", ref, u)
+	}
+
+	token, err := ref.Read(ctx)
+	if err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err = ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Value != "glpat-new" || secret.Attributes.Expires == nil || *secret.Attributes.Expires != expiresAt.Unix() {
+		t.Errorf("unexpected secret after Update(), %+v", secret)
+	}
+	if secret.Tags["owner"] != "platform" || secret.ContentType != "text/plain" {
+		t.Errorf("Update() did not retain content type and tags, %+v", secret)
+	}
+
+	metadata, err := ref.(*TokenReference).Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.ExpiresAt.Equal(expiresAt) || metadata.Version != "4387e9f3d6e14c459867679a90fd0f79" {
+		t.Errorf("Metadata() = %+v", metadata)
+	}
+}
+
+func TestNewFromURL(t *testing.T) {
+	u, _ := url.Parse("azkv://my-vault/gitlab-pat")
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.(*TokenReference).endpoint != "https://my-vault.vault.azure.net" {
+		t.Errorf("unexpected endpoint %s", ref.(*TokenReference).endpoint)
+	}
+
+	for _, referenceURL := range []string{"azkv:///gitlab-pat", "azkv://my-vault/", "azkv://my-vault/a/b"} {
+		u, _ = url.Parse(referenceURL)
+		if _, err = NewFromURL(context.Background(), u); err == nil {
+			t.Errorf("NewFromURL(%s) expected an error", referenceURL)
+		}
+	}
+}
+
+func TestUpdateFailsWhenCurrentVersionCannotBeRead(t *testing.T) {
+	written := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "expires_in": 3600})
+	})
+	mux.HandleFunc("/secrets/gitlab-pat", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			written = true
+			_ = json.NewEncoder(w).Encode(secretBundle{})
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error": {"message": "caller is not authorized to get the secret"}}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL)
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+	u, _ := url.Parse("azkv://my-vault/gitlab-pat?endpoint=" + url.QueryEscape(server.URL))
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ref.Update(context.Background(), "glpat-new", time.Now()); err == nil {
+		t.Error("expected the failed read of the current version to be returned")
+	}
+	if written {
+		t.Error("expected no new version without the tags and content type of the current version")
+	}
+}
+
+// redirectTransport sends all requests to the test server, regardless of their host.
+type redirectTransport struct {
+	server *url.URL
+}
+
+func (r redirectTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.URL.Scheme, request.URL.Host = r.server.Scheme, r.server.Host
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func TestSovereignCloudScope(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		scope    string
+	}{
+		{"", "https://vault.azure.net/.default"},
+		{"https://my-vault.vault.azure.cn", "https://vault.azure.cn/.default"},
+		{"https://my-vault.vault.usgovcloudapi.net/", "https://vault.usgovcloudapi.net/.default"},
+		{"https://my-vault.privatelink.vaultcore.azure.net", "https://vault.azure.net/.default"},
+	}
+	for _, tt := range tests {
+		var scope string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+			scope = r.FormValue("scope")
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "expires_in": 3600})
+		})
+		mux.HandleFunc("/secrets/gitlab-pat", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(secretBundle{Value: "glpat-old"})
+		})
+		server := httptest.NewServer(mux)
+		serverURL, _ := url.Parse(server.URL)
+
+		t.Setenv("AZURE_AUTHORITY_HOST", "https://login.microsoftonline.com")
+		t.Setenv("AZURE_TENANT_ID", "tenant")
+		t.Setenv("AZURE_CLIENT_ID", "client")
+		t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+		ref, err := NewTokenReference(context.Background(), "my-vault", "gitlab-pat", tt.endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref.credentials.httpClient = &http.Client{Transport: redirectTransport{serverURL}}
+		if _, err = ref.Read(context.Background()); err != nil {
+			t.Errorf("Read() of %s failed, %v", ref, err)
+		}
+		if scope != tt.scope {
+			t.Errorf("expected an access token for %s with scope %s, got %s", ref, tt.scope, scope)
+		}
+		server.Close()
+	}
+}
+
+// newTestReference creates a reference to the secret gitlab-pat in a fake Key Vault, serving the
+// secret from the handlers registered on mux.
+func newTestReference(t *testing.T, mux *http.ServeMux) *TokenReference {
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "expires_in": 3600})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL)
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+	u, _ := url.Parse("azkv://my-vault/gitlab-pat?endpoint=" + url.QueryEscape(server.URL))
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.(*TokenReference)
+}
+
+// versionsPages serves the versions of the secret gitlab-pat in pages of two, and v3 as the current version.
+func versionsPages(mux *http.ServeMux, enabled map[string]bool) {
+	ids := []string{"v1", "v2", "v3", "v4", "v5"}
+	versionID := func(r *http.Request, id string) string {
+		return "http://" + r.Host + "/secrets/gitlab-pat/" + id
+	}
+	mux.HandleFunc("GET /secrets/gitlab-pat", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": versionID(r, "v3"), "value": "glpat-3"})
+	})
+	mux.HandleFunc("GET /secrets/gitlab-pat/versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" || r.URL.Query().Get("api-version") != apiVersion {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("$skiptoken"))
+		var values []map[string]any
+		for i := page * 2; i < len(ids) && i < page*2+2; i++ {
+			values = append(values, map[string]any{
+				"id":         versionID(r, ids[i]),
+				"attributes": map[string]any{"enabled": enabled[ids[i]], "created": 1717200000 + i*60},
+			})
+		}
+		response := map[string]any{"value": values, "nextLink": nil}
+		if page*2+2 < len(ids) {
+			response["nextLink"] = fmt.Sprintf("http://%s/secrets/gitlab-pat/versions?api-version=%s&$skiptoken=%d", r.Host, apiVersion, page+1)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	})
+}
+
+func TestVersionsFollowsNextLink(t *testing.T) {
+	mux := http.NewServeMux()
+	versionsPages(mux, map[string]bool{"v1": true, "v2": false, "v3": true, "v4": true, "v5": true})
+	ref := newTestReference(t, mux)
+
+	versions, err := ref.Versions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, version := range versions {
+		ids = append(ids, version.ID)
+		if version.Current != (version.ID == "v3") {
+			t.Errorf("expected only v3 to be current, got %+v", version)
+		}
+	}
+	if strings.Join(ids, ",") != "v5,v4,v3,v1" {
+		t.Errorf("expected the enabled versions of all pages, newest first, got %v", ids)
+	}
+}
+
+func TestVersionsRejectsForeignNextLink(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /secrets/gitlab-pat", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": "http://" + r.Host + "/secrets/gitlab-pat/v1"})
+	})
+	mux.HandleFunc("GET /secrets/gitlab-pat/versions", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"value": []any{}, "nextLink": "https://attacker.example.com/versions"})
+	})
+	ref := newTestReference(t, mux)
+
+	if _, err := ref.Versions(context.Background()); err == nil {
+		t.Error("expected an error for a next link outside the Key Vault")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	enabled := map[string]bool{"v1": true, "v2": true, "v3": true, "v4": true, "v5": true}
+	mux := http.NewServeMux()
+	versionsPages(mux, enabled)
+	mux.HandleFunc("PATCH /secrets/gitlab-pat/{version}", func(w http.ResponseWriter, r *http.Request) {
+		var update secretBundle
+		_ = json.NewDecoder(r.Body).Decode(&update)
+		if update.Attributes.Enabled == nil || *update.Attributes.Enabled {
+			t.Errorf("expected version %s to be disabled, got %+v", r.PathValue("version"), update)
+		}
+		enabled[r.PathValue("version")] = false
+		_ = json.NewEncoder(w).Encode(update)
+	})
+	ref := newTestReference(t, mux)
+
+	if err := ref.Prune(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	// v3 is the current version, which is kept although it is not one of the newest two
+	for version, expected := range map[string]bool{"v1": false, "v2": false, "v3": true, "v4": true, "v5": true} {
+		if enabled[version] != expected {
+			t.Errorf("expected %s to be enabled %t, got %t", version, expected, enabled[version])
+		}
+	}
+}
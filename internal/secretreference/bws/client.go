@@ -0,0 +1,153 @@
+package bws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// client is a minimal Bitwarden Secrets Manager API client, configured in the same way as the bws CLI.
+type client struct {
+	apiURL      string
+	identityURL string
+	httpClient  *http.Client
+
+	mutex           sync.Mutex
+	bearerToken     string
+	organizationKey *symmetricKey
+}
+
+// newClient creates a client for the server in BWS_SERVER_URL, or BWS_API_URL and BWS_IDENTITY_URL.
+// Without configuration, the US cloud is used.
+func newClient() *client {
+	apiURL, identityURL := "https://api.bitwarden.com", "https://identity.bitwarden.com"
+	if serverURL := strings.TrimSuffix(os.Getenv("BWS_SERVER_URL"), "/"); serverURL != "" {
+		apiURL, identityURL = serverURL+"/api", serverURL+"/identity"
+	}
+	if value := os.Getenv("BWS_API_URL"); value != "" {
+		apiURL = value
+	}
+	if value := os.Getenv("BWS_IDENTITY_URL"); value != "" {
+		identityURL = value
+	}
+	return &client{
+		apiURL:      strings.TrimSuffix(apiURL, "/"),
+		identityURL: strings.TrimSuffix(identityURL, "/"),
+		httpClient:  http.DefaultClient,
+	}
+}
+
+// login logs in with the machine account access token in BWS_ACCESS_TOKEN, and decrypts the
+// organization key from the login response.
+func (c *client) login(ctx context.Context) (string, *symmetricKey, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.bearerToken != "" {
+		return c.bearerToken, c.organizationKey, nil
+	}
+
+	token, err := parseAccessToken(os.Getenv("BWS_ACCESS_TOKEN"))
+	if err != nil {
+		return "", nil, fmt.Errorf("BWS_ACCESS_TOKEN, %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"scope":         {"api.secrets"},
+		"client_id":     {token.id},
+		"client_secret": {token.clientSecret},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.identityURL+"/connect/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("bitwarden login failed with status %d", response.StatusCode)
+	}
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		EncryptedPayload string `json:"encrypted_payload"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return "", nil, err
+	}
+
+	payloadKey, err := deriveShareableKey(token.encryptionKey, "accesstoken", "sm-access-token")
+	if err != nil {
+		return "", nil, err
+	}
+	payload, err := payloadKey.decrypt(result.EncryptedPayload)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decrypt the access token payload, %w", err)
+	}
+	var keys struct {
+		EncryptionKey string `json:"encryptionKey"`
+	}
+	if err = json.Unmarshal(payload, &keys); err != nil {
+		return "", nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(keys.EncryptionKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if c.organizationKey, err = newSymmetricKey(key); err != nil {
+		return "", nil, err
+	}
+	c.bearerToken = result.AccessToken
+	return c.bearerToken, c.organizationKey, nil
+}
+
+// do executes an authenticated request on the API, and returns the organization key to encrypt
+// and decrypt values.
+func (c *client) do(ctx context.Context, method, path string, body, result any) (*symmetricKey, error) {
+	bearerToken, key, err := c.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	if body != nil {
+		if err = json.NewEncoder(&content).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, &content)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+bearerToken)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&apiError)
+		return nil, fmt.Errorf("%s %s failed with status %d: %s", method, path, response.StatusCode, apiError.Message)
+	}
+	if result == nil {
+		return key, nil
+	}
+	return key, json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,135 @@
+package bws
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// symmetricKey is a Bitwarden AES-256-CBC key with an HMAC-SHA256 key.
+type symmetricKey struct {
+	encryptionKey []byte
+	macKey        []byte
+}
+
+func newSymmetricKey(key []byte) (*symmetricKey, error) {
+	if len(key) != 64 {
+		return nil, fmt.Errorf("expected a key of 64 bytes, got %d", len(key))
+	}
+	return &symmetricKey{encryptionKey: key[:32], macKey: key[32:]}, nil
+}
+
+// deriveShareableKey derives the key to decrypt the access token payload from the secret in the access token.
+func deriveShareableKey(secret []byte, name, info string) (*symmetricKey, error) {
+	prk := hmac.New(sha256.New, []byte("bitwarden-"+name))
+	prk.Write(secret)
+
+	key := make([]byte, 64)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk.Sum(nil), []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return newSymmetricKey(key)
+}
+
+// decrypt decrypts a type 2 (AesCbc256_HmacSha256_B64) encrypted string.
+func (k *symmetricKey) decrypt(encString string) ([]byte, error) {
+	encType, value, found := strings.Cut(encString, ".")
+	if !found || encType != "2" {
+		return nil, errors.New("unsupported encrypted string type")
+	}
+	parts := strings.Split(value, "|")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid encrypted string")
+	}
+	var decoded [3][]byte
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(part); err != nil {
+			return nil, err
+		}
+	}
+	iv, data, mac := decoded[0], decoded[1], decoded[2]
+
+	if !hmac.Equal(mac, k.mac(iv, data)) {
+		return nil, errors.New("invalid mac of encrypted string")
+	}
+	if len(iv) != aes.BlockSize || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted string")
+	}
+
+	block, err := aes.NewCipher(k.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, data)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(plaintext) {
+		return nil, errors.New("invalid padding of encrypted string")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// decryptString decrypts a type 2 encrypted string to a string.
+func (k *symmetricKey) decryptString(encString string) (string, error) {
+	plaintext, err := k.decrypt(encString)
+	return string(plaintext), err
+}
+
+// encrypt encrypts the plaintext to a type 2 (AesCbc256_HmacSha256_B64) encrypted string.
+func (k *symmetricKey) encrypt(plaintext []byte) (string, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(k.encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	data := append(bytes.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	return fmt.Sprintf("2.%s|%s|%s",
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(k.mac(iv, data))), nil
+}
+
+func (k *symmetricKey) mac(iv, data []byte) []byte {
+	mac := hmac.New(sha256.New, k.macKey)
+	mac.Write(iv)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// accessToken is a parsed machine account access token, in the form 0.<id>.<client secret>:<encryption key>
+type accessToken struct {
+	id            string
+	clientSecret  string
+	encryptionKey []byte
+}
+
+func parseAccessToken(token string) (*accessToken, error) {
+	credentials, key, found := strings.Cut(token, ":")
+	parts := strings.Split(credentials, ".")
+	if !found || len(parts) != 3 || parts[0] != "0" {
+		return nil, errors.New("invalid Bitwarden access token")
+	}
+	encryptionKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(encryptionKey) != 16 {
+		return nil, errors.New("invalid encryption key in Bitwarden access token")
+	}
+	return &accessToken{id: parts[1], clientSecret: parts[2], encryptionKey: encryptionKey}, nil
+}
@@ -0,0 +1,213 @@
+package bws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+var (
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	errNoSecret = fmt.Errorf("secret %w", secretreference.ErrNotFound)
+)
+
+type TokenReference struct {
+	project string
+	secret  string
+	client  *client
+}
+
+// secretResponse is a secret as returned by the API, with encrypted key, value and note.
+type secretResponse struct {
+	ID           string    `json:"id"`
+	Key          string    `json:"key"`
+	Value        string    `json:"value"`
+	Note         string    `json:"note"`
+	RevisionDate time.Time `json:"revisionDate"`
+	Projects     []struct {
+		ID string `json:"id"`
+	} `json:"projects"`
+}
+
+func (t TokenReference) String() string {
+	return fmt.Sprintf("bws://%s/%s", t.project, t.secret)
+}
+
+// NewTokenReference create a new Bitwarden Secrets Manager token reference. The secret is
+// referenced by id, or by key within the project.
+func NewTokenReference(_ context.Context, project, secret string) (*TokenReference, error) {
+	if !uuidPattern.MatchString(project) || secret == "" {
+		return nil, errors.New("expected an url in the form bws://<project id>/<secret key or id>")
+	}
+	return &TokenReference{project: project, secret: secret, client: newClient()}, nil
+}
+
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	// bws://e325ea69-a3ab-4dff-836f-b02e013fe530/GITLAB_TOKEN
+	if referenceURL.Scheme != "bws" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	return NewTokenReference(ctx, referenceURL.Host, strings.TrimPrefix(referenceURL.Path, "/"))
+}
+
+// secretID returns the id of the secret, looking up the secret by key in the project if needed.
+func (t TokenReference) secretID(ctx context.Context) (string, error) {
+	if uuidPattern.MatchString(t.secret) {
+		return t.secret, nil
+	}
+
+	var response struct {
+		Secrets []struct {
+			ID  string `json:"id"`
+			Key string `json:"key"`
+		} `json:"secrets"`
+	}
+	key, err := t.client.do(ctx, http.MethodGet, "/projects/"+t.project+"/secrets", nil, &response)
+	if err != nil {
+		return "", err
+	}
+
+	var ids []string
+	for _, secret := range response.Secrets {
+		if name, err := key.decryptString(secret.Key); err == nil && name == t.secret {
+			ids = append(ids, secret.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("%s, %w", t, errNoSecret)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("multiple secrets with key %s found in project %s", t.secret, t.project)
+	}
+}
+
+// readSecret reads the secret, and returns the organization key to decrypt it.
+func (t TokenReference) readSecret(ctx context.Context) (*secretResponse, *symmetricKey, error) {
+	id, err := t.secretID(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var secret secretResponse
+	key, err := t.client.do(ctx, http.MethodGet, "/secrets/"+id, nil, &secret)
+	return &secret, key, err
+}
+
+// Read reads the token from the Bitwarden secret value
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	secret, key, err := t.readSecret(ctx)
+	if err != nil {
+		return "", err
+	}
+	return key.decryptString(secret.Value)
+}
+
+// noteWithExpiry returns the note with an `expires: YYYY-MM-DD` line, replacing any existing one.
+func noteWithExpiry(note string, expiresAt time.Time) string {
+	var lines []string
+	for _, line := range strings.Split(note, "\n") {
+		if line != "" && !strings.HasPrefix(line, "expires:") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(append(lines, "expires: "+expiresAt.Format(time.DateOnly)), "\n")
+}
+
+// Update updates the value of the Bitwarden secret with the token, and records the expiry
+// date in the note. The key, the rest of the note and the projects of the secret are retained.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	secret, key, err := t.readSecret(ctx)
+	if err != nil {
+		return err
+	}
+	value, err := key.encrypt([]byte(token))
+	if err != nil {
+		return err
+	}
+	note, err := key.decryptString(secret.Note)
+	if err != nil && secret.Note != "" {
+		return err
+	}
+	if secret.Note, err = key.encrypt([]byte(noteWithExpiry(note, expiresAt))); err != nil {
+		return err
+	}
+
+	projectIDs := make([]string, 0, len(secret.Projects))
+	for _, project := range secret.Projects {
+		projectIDs = append(projectIDs, project.ID)
+	}
+	_, err = t.client.do(ctx, http.MethodPut, "/secrets/"+secret.ID, map[string]any{
+		"key":        secret.Key,
+		"value":      value,
+		"note":       secret.Note,
+		"projectIds": projectIDs,
+	}, nil)
+	return err
+}
+
+// Create creates a secret with the key in the project, holding the token, with the expiry
+// date in the note. The secret must be referenced by key, as the id is assigned by Bitwarden.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	if uuidPattern.MatchString(t.secret) {
+		return fmt.Errorf("%s references a secret by id, a key is required to create it", t)
+	}
+	if _, err := t.secretID(ctx); err == nil {
+		return fmt.Errorf("%s already exists", t)
+	} else if !errors.Is(err, errNoSecret) {
+		return err
+	}
+
+	var project struct {
+		OrganizationID string `json:"organizationId"`
+	}
+	key, err := t.client.do(ctx, http.MethodGet, "/projects/"+t.project, nil, &project)
+	if err != nil {
+		return err
+	}
+
+	encrypted := make([]string, 0, 3)
+	for _, value := range []string{t.secret, token, noteWithExpiry("", expiresAt)} {
+		encryptedValue, err := key.encrypt([]byte(value))
+		if err != nil {
+			return err
+		}
+		encrypted = append(encrypted, encryptedValue)
+	}
+	_, err = t.client.do(ctx, http.MethodPost, "/organizations/"+project.OrganizationID+"/secrets", map[string]any{
+		"key":        encrypted[0],
+		"value":      encrypted[1],
+		"note":       encrypted[2],
+		"projectIds": []string{t.project},
+	}, nil)
+	return err
+}
+
+// Metadata reads the expiry date from the note of the Bitwarden secret.
+func (t TokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	secret, key, err := t.readSecret(ctx)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.UpdatedAt = secret.RevisionDate
+
+	note, err := key.decryptString(secret.Note)
+	if err != nil && secret.Note != "" {
+		return metadata, err
+	}
+	for _, line := range strings.Split(note, "\n") {
+		if expires, ok := strings.CutPrefix(line, "expires:"); ok {
+			if metadata.ExpiresAt, err = time.Parse(time.DateOnly, strings.TrimSpace(expires)); err != nil {
+				return metadata, fmt.Errorf("invalid expires line in the note of %s: %w", t, err)
+			}
+		}
+	}
+	return metadata, nil
+}
@@ -0,0 +1,189 @@
+package bws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	projectID = "e325ea69-a3ab-4dff-836f-b02e013fe530"
+	secretID  = "9a8c5a36-4b8f-4d8c-9a0e-b02e013fe531"
+)
+
+// fakeBitwarden is a minimal stand-in for the Bitwarden identity and Secrets Manager API.
+type fakeBitwarden struct {
+	t                *testing.T
+	accessToken      string
+	encryptedPayload string
+	organizationKey  *symmetricKey
+	secret           map[string]any
+	created          map[string]any
+}
+
+func newFakeBitwarden(t *testing.T) *fakeBitwarden {
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		_, _ = rand.Read(b)
+		return b
+	}
+	encryptionKey, organizationKey := random(16), random(64)
+	f := &fakeBitwarden{
+		t:           t,
+		accessToken: "0.access-token-id.client-secret:" + base64.StdEncoding.EncodeToString(encryptionKey),
+	}
+	f.organizationKey, _ = newSymmetricKey(organizationKey)
+
+	payloadKey, _ := deriveShareableKey(encryptionKey, "accesstoken", "sm-access-token")
+	payload, _ := json.Marshal(map[string]string{"encryptionKey": base64.StdEncoding.EncodeToString(organizationKey)})
+	f.encryptedPayload, _ = payloadKey.encrypt(payload)
+
+	f.secret = map[string]any{
+		"id":       secretID,
+		"key":      f.encrypt("GITLAB_TOKEN"),
+		"value":    f.encrypt("glpat-old"),
+		"note":     f.encrypt("deploy bot"),
+		"projects": []any{map[string]any{"id": projectID}},
+	}
+	return f
+}
+
+func (f *fakeBitwarden) encrypt(value string) string {
+	encrypted, err := f.organizationKey.encrypt([]byte(value))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return encrypted
+}
+
+func (f *fakeBitwarden) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/identity/connect/token" {
+		if r.FormValue("client_id") != "access-token-id" || r.FormValue("client_secret") != "client-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "bearer", "encrypted_payload": f.encryptedPayload})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer bearer" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/api/projects/"+projectID+"/secrets":
+		_ = json.NewEncoder(w).Encode(map[string]any{"secrets": []any{
+			map[string]any{"id": "00000000-0000-0000-0000-000000000000", "key": f.encrypt("OTHER")},
+			map[string]any{"id": secretID, "key": f.secret["key"]},
+		}})
+	case r.URL.Path == "/api/projects/"+projectID:
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": projectID, "organizationId": "organization-id"})
+	case r.URL.Path == "/api/organizations/organization-id/secrets" && r.Method == http.MethodPost:
+		_ = json.NewDecoder(r.Body).Decode(&f.created)
+		_ = json.NewEncoder(w).Encode(f.created)
+	case r.URL.Path == "/api/secrets/"+secretID && r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(f.secret)
+	case r.URL.Path == "/api/secrets/"+secretID && r.Method == http.MethodPut:
+		var update map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&update)
+		for name, value := range update {
+			f.secret[name] = value
+		}
+		_ = json.NewEncoder(w).Encode(f.secret)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestReadAndUpdate(t *testing.T) {
+	bitwarden := newFakeBitwarden(t)
+	server := httptest.NewServer(bitwarden)
+	defer server.Close()
+
+	t.Setenv("BWS_SERVER_URL", server.URL)
+	t.Setenv("BWS_ACCESS_TOKEN", bitwarden.accessToken)
+
+	for _, referenceURL := range []string{"bws://" + projectID + "/GITLAB_TOKEN", "bws://" + projectID + "/" + secretID} {
+		t.Run(referenceURL, func(t *testing.T) {
+			ctx := context.Background()
+			u, _ := url.Parse(referenceURL)
+			ref, err := NewFromURL(ctx, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref.(*TokenReference).String() != referenceURL {
+				t.Errorf("String() = %s, expected %s", ref, referenceURL)
+			}
+
+			bitwarden.secret["value"] = bitwarden.encrypt("glpat-old")
+			token, err := ref.Read(ctx)
+			if err != nil || token != "glpat-old" {
+				t.Fatalf("Read() = %s, %v", token, err)
+			}
+
+			expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+			if err = ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+				t.Fatal(err)
+			}
+			if value, _ := bitwarden.organizationKey.decryptString(bitwarden.secret["value"].(string)); value != "glpat-new" {
+				t.Errorf("expected updated value, got %s", value)
+			}
+			if note, _ := bitwarden.organizationKey.decryptString(bitwarden.secret["note"].(string)); note != "deploy bot\nexpires: 2024-06-01" {
+				t.Errorf("Update() did not retain the note, got %s", note)
+			}
+			if metadata, err := ref.(*TokenReference).Metadata(ctx); err != nil || !metadata.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("Metadata() = %+v, %v", metadata, err)
+			}
+			if projectIDs, _ := bitwarden.secret["projectIds"].([]any); len(projectIDs) != 1 || projectIDs[0] != projectID {
+				t.Errorf("Update() did not retain the project, got %v", bitwarden.secret["projectIds"])
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	bitwarden := newFakeBitwarden(t)
+	server := httptest.NewServer(bitwarden)
+	defer server.Close()
+
+	t.Setenv("BWS_SERVER_URL", server.URL)
+	t.Setenv("BWS_ACCESS_TOKEN", bitwarden.accessToken)
+
+	ctx := context.Background()
+	existing, _ := NewTokenReference(ctx, projectID, "GITLAB_TOKEN")
+	if err := existing.Create(ctx, "glpat-new", time.Now()); err == nil {
+		t.Errorf("Create() expected an error for an existing secret")
+	}
+
+	ref, _ := NewTokenReference(ctx, projectID, "DEPLOY_TOKEN")
+	if err := ref.Create(ctx, "glpat-new", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := bitwarden.organizationKey.decryptString(bitwarden.created["key"].(string))
+	value, _ := bitwarden.organizationKey.decryptString(bitwarden.created["value"].(string))
+	if key != "DEPLOY_TOKEN" || value != "glpat-new" {
+		t.Errorf("unexpected secret created, %s=%s", key, value)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, _ := newSymmetricKey(make([]byte, 64))
+	for _, plaintext := range []string{"", "glpat", "exactly 16 bytes"} {
+		encrypted, err := key.encrypt([]byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := key.decryptString(encrypted); err != nil || decrypted != plaintext {
+			t.Errorf("decryptString(encrypt(%q)) = %q, %v", plaintext, decrypted, err)
+		}
+	}
+	if _, err := key.decrypt("2.AAAA|AAAA|AAAA"); err == nil {
+		t.Errorf("expected an error on an invalid mac")
+	}
+}
@@ -0,0 +1,326 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"token-manager/internal/secretreference"
+)
+
+// document is the content of a file containing a token, either as plain text or in a field
+// of a structured document.
+type document interface {
+	get() (string, error)
+	set(token string) error
+	bytes() ([]byte, error)
+}
+
+// plainDocument is a file containing only the token.
+type plainDocument struct {
+	content string
+}
+
+func (d *plainDocument) get() (string, error) {
+	return strings.TrimRight(d.content, "\r\n"), nil
+}
+
+func (d *plainDocument) set(token string) error {
+	d.content = token + "\n"
+	return nil
+}
+
+func (d *plainDocument) bytes() ([]byte, error) {
+	return []byte(d.content), nil
+}
+
+// jsonDocument is a JSON file in which the token is referenced by a JSON pointer (RFC 6901).
+// Numbers and the order of object members are retained, and the indentation of the file is kept.
+type jsonDocument struct {
+	pointer []string
+	indent  string
+	content any
+}
+
+// jsonObject is a JSON object which retains the order of its members.
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: map[string]any{}}
+}
+
+func (o *jsonObject) get(name string) (any, bool) {
+	value, ok := o.values[name]
+	return value, ok
+}
+
+func (o *jsonObject) set(name string, value any) {
+	if _, ok := o.values[name]; !ok {
+		o.keys = append(o.keys, name)
+	}
+	o.values[name] = value
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		name, err := marshalJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshalJSON(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// marshalJSON marshals the value without escaping HTML characters, so that strings are written as read.
+func marshalJSON(value any) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buffer.Bytes(), "\n"), nil
+}
+
+// decodeJSON decodes the next value from the decoder, retaining numbers and the order of object members.
+func decodeJSON(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := newJSONObject()
+		for decoder.More() {
+			if token, err = decoder.Token(); err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object.set(token.(string), value)
+		}
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := []any{}
+		for decoder.More() {
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token()
+		return array, err
+	default:
+		return token, nil
+	}
+}
+
+// detectIndent returns the indentation of the first indented line of the content, or the default.
+// Comment lines are skipped.
+func detectIndent(content []byte, defaultIndent string) string {
+	for _, line := range strings.Split(string(content), "\n")[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return defaultIndent
+}
+
+func newJSONDocument(content []byte, pointer string) (*jsonDocument, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %s", pointer)
+	}
+	d := &jsonDocument{indent: detectIndent(content, "  ")}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		d.pointer = append(d.pointer, strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~"))
+	}
+	if len(content) == 0 {
+		d.content = newJSONObject()
+		return d, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var err error
+	if d.content, err = decodeJSON(decoder); err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON document, unexpected content after the top-level value")
+	}
+	return d, nil
+}
+
+func (d *jsonDocument) get() (string, error) {
+	value := d.content
+	for _, name := range d.pointer {
+		switch node := value.(type) {
+		case *jsonObject:
+			value, _ = node.get(name)
+		case []any:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", fmt.Errorf("invalid array index %s", name)
+			}
+			value = node[index]
+		default:
+			value = nil
+		}
+		if value == nil {
+			return "", fmt.Errorf("value at /%s %w", strings.Join(d.pointer, "/"), secretreference.ErrNotFound)
+		}
+	}
+	token, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("value at /%s is not a string", strings.Join(d.pointer, "/"))
+	}
+	return token, nil
+}
+
+func (d *jsonDocument) set(token string) error {
+	parent := d.content
+	for i, name := range d.pointer {
+		last := i == len(d.pointer)-1
+		switch node := parent.(type) {
+		case *jsonObject:
+			if last {
+				node.set(name, token)
+				return nil
+			}
+			if _, ok := node.get(name); !ok {
+				node.set(name, newJSONObject())
+			}
+			parent, _ = node.get(name)
+		case []any:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(node) {
+				return fmt.Errorf("invalid array index %s", name)
+			}
+			if last {
+				node[index] = token
+				return nil
+			}
+			parent = node[index]
+		default:
+			return fmt.Errorf("cannot set /%s, %s is not an object or array",
+				strings.Join(d.pointer, "/"), name)
+		}
+	}
+	return errors.New("empty JSON pointer")
+}
+
+func (d *jsonDocument) bytes() ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", d.indent)
+	err := encoder.Encode(d.content)
+	return buffer.Bytes(), err
+}
+
+// yamlDocument is a YAML file in which the token is referenced by a dot separated key.
+// The document is updated in place, so comments, ordering and indentation are retained.
+type yamlDocument struct {
+	path    []string
+	indent  int
+	content yaml.Node
+}
+
+func newYAMLDocument(content []byte, key string) (*yamlDocument, error) {
+	if key == "" {
+		return nil, errors.New("empty YAML key")
+	}
+	d := &yamlDocument{path: strings.Split(key, "."), indent: len(detectIndent(content, "    "))}
+	if err := yaml.Unmarshal(content, &d.content); err != nil {
+		return nil, err
+	}
+	if d.content.Kind == 0 {
+		d.content = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	return d, nil
+}
+
+// lookup returns the node with the name in the mapping node, or nil if absent.
+func lookup(node *yaml.Node, name string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func (d *yamlDocument) get() (string, error) {
+	node := d.content.Content[0]
+	for _, name := range d.path {
+		if node.Kind != yaml.MappingNode {
+			return "", fmt.Errorf("value at %s %w", strings.Join(d.path, "."), secretreference.ErrNotFound)
+		}
+		if node = lookup(node, name); node == nil {
+			return "", fmt.Errorf("value at %s %w", strings.Join(d.path, "."), secretreference.ErrNotFound)
+		}
+	}
+	if node.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("value at %s is not a string", strings.Join(d.path, "."))
+	}
+	return node.Value, nil
+}
+
+func (d *yamlDocument) set(token string) error {
+	node := d.content.Content[0]
+	for _, name := range d.path {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("cannot set %s, %s is not a mapping", strings.Join(d.path, "."), name)
+		}
+		child := lookup(node, name)
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, child)
+		}
+		node = child
+	}
+	if node.Kind != yaml.ScalarNode {
+		*node = yaml.Node{Kind: yaml.ScalarNode}
+	}
+	node.Tag = "!!str"
+	node.Value = token
+	return nil
+}
+
+func (d *yamlDocument) bytes() ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(d.indent)
+	if err := encoder.Encode(&d.content); err != nil {
+		return nil, err
+	}
+	err := encoder.Close()
+	return buffer.Bytes(), err
+}
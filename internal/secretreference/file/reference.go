@@ -0,0 +1,159 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+type TokenReference struct {
+	path     string
+	fragment string
+}
+
+func (t TokenReference) String() string {
+	u := url.URL{Scheme: "file", Path: t.path, Fragment: t.fragment}
+	return u.String()
+}
+
+// NewTokenReference create a new local file token reference. If a fragment is specified, the token
+// is stored in the field referenced by the JSON pointer or YAML key of the document.
+func NewTokenReference(_ context.Context, path, fragment string) (*TokenReference, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("expected an absolute path, got %s", path)
+	}
+	if fragment != "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json", ".yaml", ".yml":
+		default:
+			return nil, fmt.Errorf("a field can only be selected in a .json, .yaml or .yml file, got %s", path)
+		}
+	}
+	return &TokenReference{path: path, fragment: fragment}, nil
+}
+
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	// file:///home/me/.config/gitlab/token
+	// file:///home/me/.config/credentials.json#/gitlab/token
+	if referenceURL.Scheme != "file" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	if (referenceURL.Host != "" && referenceURL.Host != "localhost") || referenceURL.Path == "" {
+		return nil, errors.New("expected an url in the form file:///<path>#<json pointer or yaml key>")
+	}
+	return NewTokenReference(ctx, referenceURL.Path, referenceURL.Fragment)
+}
+
+// document parses the content of the file
+func (t TokenReference) document(content []byte) (document, error) {
+	if t.fragment == "" {
+		return &plainDocument{content: string(content)}, nil
+	}
+	if strings.ToLower(filepath.Ext(t.path)) == ".json" {
+		return newJSONDocument(content, t.fragment)
+	}
+	return newYAMLDocument(content, t.fragment)
+}
+
+// readFile reads the content of the file, refusing files which are readable by group or others.
+func (t TokenReference) readFile() ([]byte, fs.FileMode, error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, 0, fmt.Errorf("%s is accessible by group or others, expected mode 0600 or stricter", t.path)
+	}
+	content, err := os.ReadFile(t.path)
+	return content, info.Mode().Perm(), err
+}
+
+// Read reads the token from the file.
+func (t TokenReference) Read(_ context.Context) (string, error) {
+	content, _, err := t.readFile()
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%s %w", t.path, secretreference.ErrNotFound)
+	} else if err != nil {
+		return "", err
+	}
+	doc, err := t.document(content)
+	if err != nil {
+		return "", err
+	}
+	return doc.get()
+}
+
+// Update writes the token to the file. The file is written to a temporary file in the same
+// directory, and renamed, so that the update is atomic. A missing file is created with mode 0600.
+func (t TokenReference) Update(_ context.Context, token string, expiresAt time.Time) error {
+	content, mode, err := t.readFile()
+	if errors.Is(err, fs.ErrNotExist) {
+		mode = 0o600
+	} else if err != nil {
+		return err
+	}
+
+	doc, err := t.document(content)
+	if err != nil {
+		return err
+	}
+	if err = doc.set(token); err != nil {
+		return err
+	}
+	if content, err = doc.bytes(); err != nil {
+		return err
+	}
+	return WriteFileAtomically(t.path, content, mode)
+}
+
+// Create writes the token to the file, if the file or the field in the document does not exist yet.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	if _, err := t.Read(ctx); err == nil {
+		return fmt.Errorf("%s already exists", t)
+	}
+	return t.Update(ctx, token, expiresAt)
+}
+
+// WriteFileAtomically writes content to a temporary file with mode in the directory of path, and
+// renames it to path. Readers see either the old or the new content, never a partial write.
+func WriteFileAtomically(path string, content []byte, mode fs.FileMode) error {
+	temporary, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	defer temporary.Close()
+
+	if err = temporary.Chmod(mode); err != nil {
+		return err
+	}
+	if _, err = temporary.Write(content); err != nil {
+		return err
+	}
+	if err = temporary.Sync(); err != nil {
+		return err
+	}
+	if err = temporary.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), path)
+}
+
+// Metadata returns the modification time of the file. A plain file has no place to store
+// the expiry date of the token, so it is not returned.
+func (t TokenReference) Metadata(_ context.Context) (metadata secretreference.Metadata, err error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.UpdatedAt = info.ModTime()
+	return metadata, nil
+}
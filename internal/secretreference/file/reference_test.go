@@ -0,0 +1,137 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+func newReference(t *testing.T, referenceURL string) *TokenReference {
+	u, err := url.Parse(referenceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.(*TokenReference).String() != referenceURL {
+		t.Errorf("String() = %s, expected %s", ref, referenceURL)
+	}
+	return ref.(*TokenReference)
+}
+
+func TestReadAndUpdate(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		file     string
+		fragment string
+		content  string
+		expected string
+	}{
+		{"plain", "token", "", "glpat-old\n", "glpat-new\n"},
+		{"json", "credentials.json", "#/gitlab/token",
+			`{"gitlab": {"token": "glpat-old", "user": "bot"}}`,
+			"{\n  \"gitlab\": {\n    \"token\": \"glpat-new\",\n    \"user\": \"bot\"\n  }\n}\n"},
+		{"yaml", "credentials.yaml", "#gitlab.token",
+			"# credentials\ngitlab:\n    user: bot\n    token: glpat-old # rotated\n",
+			"# credentials\ngitlab:\n    user: bot\n    token: glpat-new # rotated\n"},
+		{"json retaining numbers, order and indentation", "ordered.json", "#/token",
+			"{\n    \"user\": \"bot\",\n    \"id\": 12345678901234567890,\n    \"url\": \"https://gitlab.com/?a=1&b=<2>\",\n    \"token\": \"glpat-old\"\n}\n",
+			"{\n    \"user\": \"bot\",\n    \"id\": 12345678901234567890,\n    \"url\": \"https://gitlab.com/?a=1&b=<2>\",\n    \"token\": \"glpat-new\"\n}\n"},
+		{"yaml retaining indentation", "indented.yaml", "#gitlab.token",
+			"gitlab:\n  # the bot user\n  user: bot\n  token: glpat-old\n  scopes:\n    - api\n",
+			"gitlab:\n  # the bot user\n  user: bot\n  token: glpat-new\n  scopes:\n    - api\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o400); err != nil {
+				t.Fatal(err)
+			}
+			ref := newReference(t, "file://"+path+tt.fragment)
+
+			token, err := ref.Read(ctx)
+			if err != nil || token != "glpat-old" {
+				t.Fatalf("Read() = %s, %v", token, err)
+			}
+			if err = ref.Update(ctx, "glpat-new", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+
+			content, _ := os.ReadFile(path)
+			if string(content) != tt.expected {
+				t.Errorf("unexpected content after Update()\n%s\nexpected\n%s", content, tt.expected)
+			}
+			if info, _ := os.Stat(path); info.Mode().Perm() != 0o400 {
+				t.Errorf("Update() changed the mode to %o", info.Mode().Perm())
+			}
+			if matches, _ := filepath.Glob(filepath.Join(dir, ".*")); len(matches) > 0 {
+				t.Errorf("temporary files left behind, %v", matches)
+			}
+		})
+	}
+}
+
+func TestUpdateCreatesMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.yaml")
+	ref := newReference(t, "file://"+path+"#gitlab.token")
+	if err := ref.Update(context.Background(), "glpat-new", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected file with mode 0600, got %v, %v", info, err)
+	}
+	if token, err := ref.Read(context.Background()); err != nil || token != "glpat-new" {
+		t.Errorf("Read() = %s, %v", token, err)
+	}
+}
+
+func TestReadMissingToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.yaml")
+	ref := newReference(t, "file://"+path+"#gitlab.token")
+	if _, err := ref.Read(context.Background()); !errors.Is(err, secretreference.ErrNotFound) {
+		t.Errorf("Read() expected not found for a missing file, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte("gitlab:\n  user: bot\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Read(context.Background()); !errors.Is(err, secretreference.ErrNotFound) {
+		t.Errorf("Read() expected not found for a missing field, got %v", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	ref := newReference(t, "file://"+path)
+	if err := ref.Create(context.Background(), "glpat-new", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ref.Create(context.Background(), "glpat-new", time.Now()); err == nil {
+		t.Errorf("Create() expected an error for an existing file")
+	}
+}
+
+func TestRefuseReadableFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("glpat-old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ref := newReference(t, "file://"+path)
+	if _, err := ref.Read(context.Background()); err == nil || !strings.Contains(err.Error(), "group or others") || errors.Is(err, secretreference.ErrNotFound) {
+		t.Errorf("Read() expected an error on a world readable file, got %v", err)
+	}
+	if err := ref.Update(context.Background(), "glpat-new", time.Now()); err == nil {
+		t.Errorf("Update() expected an error on a world readable file")
+	}
+}
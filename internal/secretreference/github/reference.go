@@ -0,0 +1,198 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+var (
+	repositoryPattern   = regexp.MustCompile(`^/([^/]+)/actions/secrets/([^/]+)$`)
+	environmentPattern  = regexp.MustCompile(`^/([^/]+)/environments/([^/]+)/secrets/([^/]+)$`)
+	organizationPattern = regexp.MustCompile(`^/actions/secrets/([^/]+)$`)
+	expectError         = errors.New("expected github://<owner>/<repo>/actions/secrets/<name>, " +
+		"github://<owner>/<repo>/environments/<environment>/secrets/<name> or github://<org>/actions/secrets/<name>")
+	errNotFound = secretreference.ErrNotFound
+)
+
+// TokenReference references a GitHub Actions secret of a repository, environment or organization.
+// GitHub secrets cannot be read, so the token can only be written.
+type TokenReference struct {
+	url        *url.URL
+	secretPath string
+	name       string
+	visibility string
+	apiURL     string
+	httpClient *http.Client
+}
+
+func (t TokenReference) String() string {
+	return t.url.String()
+}
+
+// WriteOnly returns true, as the value of a GitHub Actions secret cannot be read.
+func (t TokenReference) WriteOnly() bool {
+	return true
+}
+
+// NewFromURL create a new GitHub Actions secret reference. The GitHub API is accessed through
+// GITHUB_API_URL using GITHUB_TOKEN or GH_TOKEN.
+func NewFromURL(_ context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	if referenceURL.Scheme != "github" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	if referenceURL.Host == "" {
+		return nil, expectError
+	}
+
+	ref := TokenReference{url: referenceURL, httpClient: http.DefaultClient}
+	owner := url.PathEscape(referenceURL.Host)
+	if match := repositoryPattern.FindStringSubmatch(referenceURL.Path); match != nil {
+		ref.secretPath = fmt.Sprintf("/repos/%s/%s/actions/secrets", owner, url.PathEscape(match[1]))
+		ref.name = match[2]
+	} else if match = environmentPattern.FindStringSubmatch(referenceURL.Path); match != nil {
+		ref.secretPath = fmt.Sprintf("/repos/%s/%s/environments/%s/secrets",
+			owner, url.PathEscape(match[1]), url.PathEscape(match[2]))
+		ref.name = match[3]
+	} else if match = organizationPattern.FindStringSubmatch(referenceURL.Path); match != nil {
+		ref.secretPath = fmt.Sprintf("/orgs/%s/actions/secrets", owner)
+		ref.name = match[1]
+		ref.visibility = referenceURL.Query().Get("visibility")
+		if ref.visibility == "" {
+			ref.visibility = "private"
+		}
+	} else {
+		return nil, expectError
+	}
+
+	ref.apiURL = strings.TrimSuffix(os.Getenv("GITHUB_API_URL"), "/")
+	if ref.apiURL == "" {
+		ref.apiURL = "https://api.github.com"
+	}
+	return &ref, nil
+}
+
+func (t TokenReference) do(ctx context.Context, method, path string, body, result any) error {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		token = os.Getenv("GH_TOKEN")
+	}
+	if token == "" {
+		return errors.New("GITHUB_TOKEN or GH_TOKEN is required to write GitHub Actions secrets")
+	}
+
+	var content bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&content).Encode(body); err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, method, t.apiURL+path, &content)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	response, err := t.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&apiError)
+		if response.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%s %s, %w", method, path, errNotFound)
+		}
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, response.StatusCode, apiError.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// Read always fails, as GitHub Actions secrets cannot be read.
+func (t TokenReference) Read(_ context.Context) (string, error) {
+	return "", fmt.Errorf("%s is a %w, the token cannot be read", t, secretreference.ErrWriteOnly)
+}
+
+// Update encrypts the token with the public key of the repository, environment or organization
+// in a sealed box, and creates or updates the secret.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	var publicKey struct {
+		KeyID string `json:"key_id"`
+		Key   string `json:"key"`
+	}
+	if err := t.do(ctx, http.MethodGet, t.secretPath+"/public-key", nil, &publicKey); err != nil {
+		return err
+	}
+
+	encryptedValue, err := seal(token, publicKey.Key)
+	if err != nil {
+		return err
+	}
+
+	secret := map[string]string{"encrypted_value": encryptedValue, "key_id": publicKey.KeyID}
+	if t.visibility != "" {
+		secret["visibility"] = t.visibility
+	}
+	return t.do(ctx, http.MethodPut, t.secretPath+"/"+url.PathEscape(t.name), secret, nil)
+}
+
+// Create writes the token to the GitHub Actions secret, if the secret does not exist yet.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	err := t.do(ctx, http.MethodGet, t.secretPath+"/"+url.PathEscape(t.name), nil, nil)
+	if err == nil {
+		return fmt.Errorf("%s already exists", t)
+	}
+	if !errors.Is(err, errNotFound) {
+		return err
+	}
+	return t.Update(ctx, token, expiresAt)
+}
+
+// Metadata returns the time the GitHub Actions secret was last updated. GitHub has no place to
+// store the expiry date of the token, so it is not returned.
+func (t TokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	var secret struct {
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	if err = t.do(ctx, http.MethodGet, t.secretPath+"/"+url.PathEscape(t.name), nil, &secret); err != nil {
+		return metadata, err
+	}
+	metadata.UpdatedAt = secret.UpdatedAt
+	return metadata, nil
+}
+
+// seal encrypts the value in a libsodium sealed box for the base64 encoded public key.
+func seal(value string, publicKey string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != 32 {
+		return "", errors.New("invalid GitHub secrets public key")
+	}
+	encrypted, err := box.SealAnonymous(nil, []byte(value), (*[32]byte)(key), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
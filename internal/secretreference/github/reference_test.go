@@ -0,0 +1,143 @@
+package github
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"token-manager/internal/secretreference"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestUpdate(t *testing.T) {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		referenceURL string
+		path         string
+		visibility   string
+	}{
+		{"github://xebia/token-manager/actions/secrets/GITLAB_TOKEN", "/repos/xebia/token-manager/actions/secrets", ""},
+		{"github://xebia/token-manager/environments/production/secrets/GITLAB_TOKEN", "/repos/xebia/token-manager/environments/production/secrets", ""},
+		{"github://xebia/actions/secrets/GITLAB_TOKEN", "/orgs/xebia/actions/secrets", "private"},
+		{"github://xebia/actions/secrets/GITLAB_TOKEN?visibility=all", "/orgs/xebia/actions/secrets", "all"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.referenceURL, func(t *testing.T) {
+			var secret map[string]string
+			mux := http.NewServeMux()
+			mux.HandleFunc(tt.path+"/public-key", func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(map[string]string{
+					"key_id": "key-1", "key": base64.StdEncoding.EncodeToString(publicKey[:]),
+				})
+			})
+			mux.HandleFunc(tt.path+"/GITLAB_TOKEN", func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer github-token" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				_ = json.NewDecoder(r.Body).Decode(&secret)
+				w.WriteHeader(http.StatusNoContent)
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			t.Setenv("GITHUB_API_URL", server.URL)
+			t.Setenv("GITHUB_TOKEN", "github-token")
+
+			ctx := context.Background()
+			u, _ := url.Parse(tt.referenceURL)
+			ref, err := NewFromURL(ctx, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !secretreference.IsWriteOnly(ref) {
+				t.Errorf("expected a write-only reference")
+			}
+			if _, err = ref.Read(ctx); !errors.Is(err, secretreference.ErrWriteOnly) {
+				t.Errorf("Read() expected a write-only error, got %v", err)
+			}
+
+			if err = ref.Update(ctx, "glpat-new", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			encrypted, _ := base64.StdEncoding.DecodeString(secret["encrypted_value"])
+			decrypted, ok := box.OpenAnonymous(nil, encrypted, publicKey, privateKey)
+			if !ok || string(decrypted) != "glpat-new" {
+				t.Errorf("failed to decrypt the secret, got %s", decrypted)
+			}
+			if secret["key_id"] != "key-1" || secret["visibility"] != tt.visibility {
+				t.Errorf("unexpected secret %v", secret)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	publicKey, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret map[string]string
+	path := "/repos/xebia/token-manager/actions/secrets"
+	mux := http.NewServeMux()
+	mux.HandleFunc(path+"/public-key", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"key_id": "key-1", "key": base64.StdEncoding.EncodeToString(publicKey[:]),
+		})
+	})
+	mux.HandleFunc(path+"/GITLAB_TOKEN", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut:
+			_ = json.NewDecoder(r.Body).Decode(&secret)
+			w.WriteHeader(http.StatusCreated)
+		case secret == nil:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		default:
+			_ = json.NewEncoder(w).Encode(map[string]string{"name": "GITLAB_TOKEN", "updated_at": "2024-03-01T12:00:00Z"})
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	t.Setenv("GITHUB_API_URL", server.URL)
+	t.Setenv("GITHUB_TOKEN", "github-token")
+
+	ctx := context.Background()
+	u, _ := url.Parse("github://xebia/token-manager/actions/secrets/GITLAB_TOKEN")
+	ref, err := NewFromURL(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = secretreference.ReadMetadata(ctx, ref); !errors.Is(err, secretreference.ErrNotFound) {
+		t.Errorf("Metadata() expected not found for a missing secret, got %v", err)
+	}
+	if err = secretreference.Create(ctx, ref, "glpat-new", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if secret["key_id"] != "key-1" {
+		t.Errorf("expected the secret to be created, got %v", secret)
+	}
+	if err = secretreference.Create(ctx, ref, "glpat-other", time.Now()); err == nil {
+		t.Error("expected an error when the secret already exists")
+	}
+}
+
+func TestNewFromURLErrors(t *testing.T) {
+	for _, referenceURL := range []string{"github:///repo/actions/secrets/NAME", "github://xebia/repo/secrets/NAME"} {
+		u, _ := url.Parse(referenceURL)
+		if _, err := NewFromURL(context.Background(), u); err == nil {
+			t.Errorf("NewFromURL(%s) expected an error", referenceURL)
+		}
+	}
+}
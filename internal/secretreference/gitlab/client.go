@@ -0,0 +1,101 @@
+package gitlab
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gl "github.com/xanzy/go-gitlab"
+	"gopkg.in/yaml.v3"
+)
+
+// httpClient is the HTTP client used to access Gitlab. It is nil for the default client.
+var httpClient *http.Client
+
+var invalidEnvironmentCharacters = regexp.MustCompile("[^A-Z0-9]+")
+
+// TokenVariable returns the name of the environment variable holding the Gitlab token for the host,
+// like GITLAB_TOKEN_GITLAB_EXAMPLE_COM for gitlab.example.com.
+func TokenVariable(host string) string {
+	return "GITLAB_TOKEN_" + strings.Trim(invalidEnvironmentCharacters.ReplaceAllString(strings.ToUpper(host), "_"), "_")
+}
+
+// credentialsFile returns the path of the file with Gitlab tokens per host, from GITLAB_CREDENTIALS_FILE
+// or ~/.config/token-manager/credentials.yaml.
+func credentialsFile() string {
+	if path := os.Getenv("GITLAB_CREDENTIALS_FILE"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "token-manager", "credentials.yaml")
+}
+
+// hostToken returns the Gitlab token for the host. It is read from the environment variable for the
+// host, or the credentials file. GITLAB_TOKEN is only used for gitlab.com, so that it is never sent to
+// another host.
+func hostToken(host string) (string, error) {
+	if token := os.Getenv(TokenVariable(host)); token != "" {
+		return token, nil
+	}
+
+	path := credentialsFile()
+	if path != "" {
+		token, err := credentialsFileToken(path, host)
+		if err != nil || token != "" {
+			return token, err
+		}
+	}
+
+	if host == "gitlab.com" {
+		if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("no token configured for host %s, set GITLAB_TOKEN, %s or add it to %s", host, TokenVariable(host), path)
+	}
+	return "", fmt.Errorf("no token configured for host %s, set %s or add it to %s", host, TokenVariable(host), path)
+}
+
+// credentialsFileToken returns the token for the host from the credentials file, or an empty string if
+// the file or the host does not exist. Like token files, it must not be accessible by group or others.
+func credentialsFileToken(path, host string) (string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("credentials file %s is accessible by group or others, expected mode 0600 or stricter", path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var credentials map[string]struct {
+		Token string `yaml:"token"`
+	}
+	if err = yaml.Unmarshal(content, &credentials); err != nil {
+		return "", fmt.Errorf("invalid credentials file %s, %w", path, err)
+	}
+	return credentials[host].Token, nil
+}
+
+// newClient creates a client for the Gitlab instance at the host of the reference URL, using the token for the host.
+func newClient(referenceURL *url.URL) (*gl.Client, error) {
+	token, err := hostToken(referenceURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	options := []gl.ClientOptionFunc{gl.WithBaseURL("https://" + referenceURL.Host)}
+	if httpClient != nil {
+		options = append(options, gl.WithHTTPClient(httpClient))
+	}
+	return gl.NewClient(token, options...)
+}
@@ -2,17 +2,29 @@ package gitlab
 
 import (
 	"context"
+	"fmt"
 	"net/url"
-	"os"
+	"strings"
 	"time"
 
 	gl "github.com/xanzy/go-gitlab"
+
+	"token-manager/internal/secretreference"
 )
 
 type GroupTokenReference struct {
-	url   *url.URL
-	group string
-	key   string
+	url              *url.URL
+	group            string
+	key              string
+	environmentScope string
+	options          variableOptions
+}
+
+func (t GroupTokenReference) EnvironmentScope() string {
+	if t.environmentScope == "" {
+		return "*"
+	}
+	return t.environmentScope
 }
 
 func (t GroupTokenReference) String() string {
@@ -23,31 +35,121 @@ func (t GroupTokenReference) Scheme() string {
 	return t.url.Scheme
 }
 
+// isASingleVariable checks of the reference points to a single CI/CD group variable
+func (t GroupTokenReference) isASingleVariable(client *gl.Client) error {
+	if t.environmentScope == "" {
+		variables, _, err := client.GroupVariables.ListVariables(t.group, &gl.ListGroupVariablesOptions{})
+		if err != nil {
+			return err
+		}
+
+		environments := make([]string, 0, len(variables))
+		for _, variable := range variables {
+			if variable.Key == t.key {
+				environments = append(environments, variable.EnvironmentScope)
+			}
+		}
+		if len(environments) > 1 {
+			return fmt.Errorf("no environment scope was specified, but variable has multiple scopes: %s",
+				strings.Join(environments, ", "))
+		}
+	}
+	return nil
+}
+
+// getVariable reads the gitlab group CI/CD variable
+func (t GroupTokenReference) getVariable(client *gl.Client) (*gl.GroupVariable, *gl.Response, error) {
+	if err := t.isASingleVariable(client); err != nil {
+		return nil, nil, err
+	}
+
+	return client.GroupVariables.GetVariable(t.group, t.key, environmentFilter(t.EnvironmentScope()))
+}
+
 // Read the token from the gitlab group CI/CD variable
 func (t GroupTokenReference) Read(ctx context.Context) (token string, err error) {
 	var client *gl.Client
-	client, err = gl.NewClient(os.Getenv("GITLAB_TOKEN"))
+	client, err = newClient(t.url)
 	if err != nil {
 		return "", err
 	}
-	variable, _, err := client.GroupVariables.GetVariable(
-		t.group,
-		t.key)
+	variable, response, err := t.getVariable(client)
+	if isNotFound(response) {
+		return "", fmt.Errorf("%s, %w", err, secretreference.ErrNotFound)
+	}
 	if err != nil {
 		return "", err
 	}
 	return variable.Value, nil
 }
 
-// Update  the token in the gitlab group CI/CD variable
+// Update  the token in the gitlab group CI/CD variable, and the expiry date in its description.
+// The attributes in the query of the reference are updated too. A missing variable is created.
 func (t GroupTokenReference) Update(ctx context.Context, token string, expiresAt time.Time) (err error) {
 	var client *gl.Client
-	client, err = gl.NewClient(os.Getenv("GITLAB_TOKEN"))
+	client, err = newClient(t.url)
 	if err != nil {
 		return err
 	}
 
+	variable, response, err := t.getVariable(client)
+	if isNotFound(response) {
+		return t.Create(ctx, token, expiresAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	environmentScope := t.EnvironmentScope()
 	_, _, err = client.GroupVariables.UpdateVariable(t.group, t.key,
-		&gl.UpdateGroupVariableOptions{Value: &token})
+		&gl.UpdateGroupVariableOptions{
+			Value:            &token,
+			Description:      t.options.describe(variable.Description, expiresAt),
+			EnvironmentScope: &environmentScope,
+			Masked:           t.options.masked,
+			Protected:        t.options.protected,
+			Raw:              t.options.raw,
+			VariableType:     t.options.variableType,
+		},
+		environmentFilter(environmentScope))
+	return err
+}
+
+// Metadata reads the expiry date of the token from the description of the gitlab group CI/CD variable
+func (t GroupTokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	var client *gl.Client
+	client, err = newClient(t.url)
+	if err != nil {
+		return metadata, err
+	}
+	variable, _, err := t.getVariable(client)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.ExpiresAt, err = parseExpiry(variable.Description)
+	return metadata, err
+}
+
+// Create creates the gitlab group CI/CD variable holding the token, with the expiry date in its description.
+// The variable is masked and protected, unless specified otherwise in the query of the reference.
+func (t GroupTokenReference) Create(ctx context.Context, token string, expiresAt time.Time) (err error) {
+	var client *gl.Client
+	client, err = newClient(t.url)
+	if err != nil {
+		return err
+	}
+
+	options := t.options.withDefaults()
+	_, _, err = client.GroupVariables.CreateVariable(t.group,
+		&gl.CreateGroupVariableOptions{
+			Key:              &t.key,
+			Value:            &token,
+			Description:      options.describe("", expiresAt),
+			EnvironmentScope: gl.Ptr(t.EnvironmentScope()),
+			Masked:           options.masked,
+			Protected:        options.protected,
+			Raw:              options.raw,
+			VariableType:     options.variableType,
+		})
 	return err
 }
@@ -0,0 +1,111 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	gl "github.com/xanzy/go-gitlab"
+
+	"token-manager/internal/secretreference"
+)
+
+// InstanceTokenReference references an instance-level CI/CD variable, which requires an administrator token.
+type InstanceTokenReference struct {
+	url     *url.URL
+	key     string
+	options variableOptions
+}
+
+func (t InstanceTokenReference) String() string {
+	return t.url.String()
+}
+
+func (t InstanceTokenReference) Scheme() string {
+	return t.url.Scheme
+}
+
+// Read the token from the gitlab instance CI/CD variable
+func (t InstanceTokenReference) Read(ctx context.Context) (token string, err error) {
+	var client *gl.Client
+	client, err = newClient(t.url)
+	if err != nil {
+		return "", err
+	}
+	variable, response, err := client.InstanceVariables.GetVariable(t.key)
+	if isNotFound(response) {
+		return "", fmt.Errorf("%s, %w", err, secretreference.ErrNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	return variable.Value, nil
+}
+
+// Update the token in the gitlab instance CI/CD variable, and the expiry date in its description.
+// The attributes in the query of the reference are updated too. A missing variable is created.
+func (t InstanceTokenReference) Update(ctx context.Context, token string, expiresAt time.Time) (err error) {
+	var client *gl.Client
+	client, err = newClient(t.url)
+	if err != nil {
+		return err
+	}
+
+	variable, response, err := client.InstanceVariables.GetVariable(t.key)
+	if isNotFound(response) {
+		return t.Create(ctx, token, expiresAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _, err = client.InstanceVariables.UpdateVariable(t.key,
+		&gl.UpdateInstanceVariableOptions{
+			Value:        &token,
+			Description:  t.options.describe(variable.Description, expiresAt),
+			Masked:       t.options.masked,
+			Protected:    t.options.protected,
+			Raw:          t.options.raw,
+			VariableType: t.options.variableType,
+		})
+	return err
+}
+
+// Metadata reads the expiry date of the token from the description of the gitlab instance CI/CD variable
+func (t InstanceTokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	var client *gl.Client
+	client, err = newClient(t.url)
+	if err != nil {
+		return metadata, err
+	}
+	variable, _, err := client.InstanceVariables.GetVariable(t.key)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.ExpiresAt, err = parseExpiry(variable.Description)
+	return metadata, err
+}
+
+// Create creates the gitlab instance CI/CD variable holding the token, with the expiry date in its description.
+// The variable is masked and protected, unless specified otherwise in the query of the reference.
+func (t InstanceTokenReference) Create(ctx context.Context, token string, expiresAt time.Time) (err error) {
+	var client *gl.Client
+	client, err = newClient(t.url)
+	if err != nil {
+		return err
+	}
+
+	options := t.options.withDefaults()
+	_, _, err = client.InstanceVariables.CreateVariable(
+		&gl.CreateInstanceVariableOptions{
+			Key:          &t.key,
+			Value:        &token,
+			Description:  options.describe("", expiresAt),
+			Masked:       options.masked,
+			Protected:    options.protected,
+			Raw:          options.raw,
+			VariableType: options.variableType,
+		})
+	return err
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
 	gl "github.com/xanzy/go-gitlab"
+
+	"token-manager/internal/secretreference"
 )
 
 type ProjectTokenReference struct {
@@ -16,6 +17,7 @@ type ProjectTokenReference struct {
 	project          string
 	key              string
 	environmentScope string
+	options          variableOptions
 }
 
 func (t ProjectTokenReference) EnvironmentScope() string {
@@ -55,45 +57,107 @@ func (t ProjectTokenReference) isASingleVariable(client *gl.Client) error {
 	return nil
 }
 
+// getVariable reads the gitlab project CI/CD variable
+func (t ProjectTokenReference) getVariable(client *gl.Client) (*gl.ProjectVariable, *gl.Response, error) {
+	if err := t.isASingleVariable(client); err != nil {
+		return nil, nil, err
+	}
+
+	return client.ProjectVariables.GetVariable(
+		t.project,
+		t.key,
+		&gl.GetProjectVariableOptions{
+			Filter: &gl.VariableFilter{EnvironmentScope: t.EnvironmentScope()},
+		},
+	)
+}
+
 // Read reads the token from the gitlab project CI/CD variable
 func (t ProjectTokenReference) Read(ctx context.Context) (token string, err error) {
 	var client *gl.Client
-	client, err = gl.NewClient(os.Getenv("GITLAB_TOKEN"))
+	client, err = newClient(t.url)
 	if err != nil {
 		return "", err
 	}
 
-	if err = t.isASingleVariable(client); err != nil {
-		return "", err
+	variable, response, err := t.getVariable(client)
+	if isNotFound(response) {
+		return "", fmt.Errorf("%s, %w", err, secretreference.ErrNotFound)
 	}
-
-	variable, _, err := client.ProjectVariables.GetVariable(
-		t.project,
-		t.key,
-		&gl.GetProjectVariableOptions{
-			&gl.VariableFilter{t.EnvironmentScope()},
-		},
-	)
 	if err != nil {
 		return "", err
 	}
 	return variable.Value, nil
 }
 
-// Update updates the token in the the gitlab project CI/CD variable
+// Metadata reads the expiry date of the token from the description of the gitlab project CI/CD variable
+func (t ProjectTokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	var client *gl.Client
+	client, err = newClient(t.url)
+	if err != nil {
+		return metadata, err
+	}
+
+	variable, _, err := t.getVariable(client)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.ExpiresAt, err = parseExpiry(variable.Description)
+	return metadata, err
+}
+
+// Update updates the token in the the gitlab project CI/CD variable, and the expiry date in its description.
+// The attributes in the query of the reference are updated too. A missing variable is created.
 func (t ProjectTokenReference) Update(ctx context.Context, token string, expiresAt time.Time) (err error) {
 	var client *gl.Client
-	client, err = gl.NewClient(os.Getenv("GITLAB_TOKEN"))
+	client, err = newClient(t.url)
 	if err != nil {
 		return err
 	}
 
-	if err = t.isASingleVariable(client); err != nil {
+	variable, response, err := t.getVariable(client)
+	if isNotFound(response) {
+		return t.Create(ctx, token, expiresAt)
+	}
+	if err != nil {
 		return err
 	}
 
 	environmentScope := t.EnvironmentScope()
 	_, _, err = client.ProjectVariables.UpdateVariable(t.project, t.key,
-		&gl.UpdateProjectVariableOptions{Value: &token, EnvironmentScope: &environmentScope})
+		&gl.UpdateProjectVariableOptions{
+			Value:            &token,
+			Description:      t.options.describe(variable.Description, expiresAt),
+			EnvironmentScope: &environmentScope,
+			Masked:           t.options.masked,
+			Protected:        t.options.protected,
+			Raw:              t.options.raw,
+			VariableType:     t.options.variableType,
+			Filter:           &gl.VariableFilter{EnvironmentScope: environmentScope},
+		})
+	return err
+}
+
+// Create creates the gitlab project CI/CD variable holding the token, with the expiry date in its description.
+// The variable is masked and protected, unless specified otherwise in the query of the reference.
+func (t ProjectTokenReference) Create(ctx context.Context, token string, expiresAt time.Time) (err error) {
+	var client *gl.Client
+	client, err = newClient(t.url)
+	if err != nil {
+		return err
+	}
+
+	options := t.options.withDefaults()
+	_, _, err = client.ProjectVariables.CreateVariable(t.project,
+		&gl.CreateProjectVariableOptions{
+			Key:              &t.key,
+			Value:            &token,
+			Description:      options.describe("", expiresAt),
+			EnvironmentScope: gl.Ptr(t.EnvironmentScope()),
+			Masked:           options.masked,
+			Protected:        options.protected,
+			Raw:              options.raw,
+			VariableType:     options.variableType,
+		})
 	return err
 }
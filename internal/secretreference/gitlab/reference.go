@@ -4,18 +4,148 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	gl "github.com/xanzy/go-gitlab"
 
 	"token-manager/internal/secretreference"
 )
 
 var (
-	urlRegex    = regexp.MustCompile("^/(projects|groups)/(.*)/variables/([^/]+)$")
-	expectError = errors.New("expected gitlab://<host>/(projects|groups)/variables/<name>")
+	urlRegex     = regexp.MustCompile("^/(projects|groups)/(.*)/variables/([^/]+)$")
+	adminRegex   = regexp.MustCompile("^/admin/variables/([^/]+)$")
+	expiresRegex = regexp.MustCompile(`expires on (\d{4}-\d{2}-\d{2})`)
+	expectError  = errors.New("expected gitlab://<host>/(projects|groups)/<id>/variables/<name> or gitlab://<host>/admin/variables/<name>")
 )
 
+// describeExpiry returns the variable description, with the expiry date of the token.
+func describeExpiry(description string, expiresAt time.Time) string {
+	expires := "expires on " + expiresAt.Format(time.DateOnly)
+	if expiresRegex.MatchString(description) {
+		return expiresRegex.ReplaceAllString(description, expires)
+	}
+	if description == "" {
+		return "token " + expires
+	}
+	return description + ", " + expires
+}
+
+// parseExpiry returns the expiry date of the token from the variable description, or the zero time
+// if the description has no expiry date.
+func parseExpiry(description string) (time.Time, error) {
+	match := expiresRegex.FindStringSubmatch(description)
+	if match == nil {
+		return time.Time{}, nil
+	}
+	expiresAt, err := time.Parse(time.DateOnly, match[1])
+	if err != nil {
+		return expiresAt, fmt.Errorf("invalid expiry date in the variable description: %w", err)
+	}
+	return expiresAt, nil
+}
+
+// variableOptions are the attributes of the CI/CD variable to set on create and update, from the
+// query of the reference URL. Attributes which are not specified are left unchanged on update.
+type variableOptions struct {
+	masked       *bool
+	protected    *bool
+	raw          *bool
+	variableType *gl.VariableTypeValue
+	description  *string
+}
+
+// queryParameters are the supported query parameters of a Gitlab variable reference.
+var queryParameters = []string{"environment", "masked", "protected", "raw", "variable_type", "description",
+	secretreference.FormatParameter}
+
+// parseVariableOptions parses the attributes of the variable from the query parameters.
+func parseVariableOptions(q url.Values) (options variableOptions, err error) {
+	for name, values := range q {
+		if !slices.Contains(queryParameters, name) {
+			return options, fmt.Errorf("unsupported query parameter %s, expected one of %s", name, strings.Join(queryParameters, ", "))
+		}
+		if len(values) > 1 {
+			return options, fmt.Errorf("query parameter %s is specified multiple times", name)
+		}
+	}
+
+	parseBool := func(name string) (*bool, error) {
+		if !q.Has(name) {
+			return nil, nil
+		}
+		value, err := strconv.ParseBool(q.Get(name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %s", name, q.Get(name))
+		}
+		return &value, nil
+	}
+	if options.masked, err = parseBool("masked"); err != nil {
+		return options, err
+	}
+	if options.protected, err = parseBool("protected"); err != nil {
+		return options, err
+	}
+	if options.raw, err = parseBool("raw"); err != nil {
+		return options, err
+	}
+	if q.Has("variable_type") {
+		variableType := gl.VariableTypeValue(q.Get("variable_type"))
+		if variableType != gl.EnvVariableType && variableType != gl.FileVariableType {
+			return options, fmt.Errorf("invalid variable_type %s, expected %s or %s", variableType, gl.EnvVariableType, gl.FileVariableType)
+		}
+		options.variableType = &variableType
+	}
+	if q.Has("description") {
+		options.description = gl.Ptr(q.Get("description"))
+	}
+	return options, nil
+}
+
+// withDefaults returns the options for a new variable, which is masked and protected unless specified otherwise.
+func (o variableOptions) withDefaults() variableOptions {
+	if o.masked == nil {
+		o.masked = gl.Ptr(true)
+	}
+	if o.protected == nil {
+		o.protected = gl.Ptr(true)
+	}
+	if o.variableType == nil {
+		o.variableType = gl.Ptr(gl.EnvVariableType)
+	}
+	return o
+}
+
+// describe returns the description of the variable with the expiry date. The description from the options
+// replaces the current description.
+func (o variableOptions) describe(current string, expiresAt time.Time) *string {
+	if o.description != nil {
+		current = *o.description
+	}
+	return gl.Ptr(describeExpiry(current, expiresAt))
+}
+
+// isNotFound returns true if the response indicates the variable does not exist.
+func isNotFound(response *gl.Response) bool {
+	return response != nil && response.StatusCode == http.StatusNotFound
+}
+
+// environmentFilter selects the variable with the environment scope.
+func environmentFilter(environmentScope string) gl.RequestOptionFunc {
+	return func(request *retryablehttp.Request) error {
+		query := request.URL.Query()
+		query.Set("filter[environment_scope]", environmentScope)
+		request.URL.RawQuery = query.Encode()
+		return nil
+	}
+}
+
 // NewFromURL create a new Gitlab variable reference
 func NewFromURL(ctx context.Context, referenceUrl *url.URL) (secretreference.SecretReference, error) {
 	var err error
@@ -30,6 +160,29 @@ func NewFromURL(ctx context.Context, referenceUrl *url.URL) (secretreference.Sec
 		return nil, expectError
 	}
 
+	q, err := url.ParseQuery(referenceUrl.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	options, err := parseVariableOptions(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if match := adminRegex.FindStringSubmatch(referenceUrl.Path); match != nil {
+		if key, err = url.QueryUnescape(match[1]); err != nil {
+			return nil, expectError
+		}
+		if q.Has("environment") {
+			return nil, fmt.Errorf("instance variables do not have an environment scope")
+		}
+		return &InstanceTokenReference{
+			url:     referenceUrl,
+			key:     key,
+			options: options,
+		}, nil
+	}
+
 	match := urlRegex.FindStringSubmatch(referenceUrl.Path)
 	if match == nil && len(match) != 4 {
 		return nil, expectError
@@ -42,33 +195,21 @@ func NewFromURL(ctx context.Context, referenceUrl *url.URL) (secretreference.Sec
 		return nil, expectError
 	}
 
-	q, err := url.ParseQuery(referenceUrl.RawQuery)
-	if err != nil {
-		return nil, err
-	}
-
 	if strings.HasPrefix(referenceUrl.Path, "/groups/") {
-		if len(q) > 0 {
-			return nil, fmt.Errorf("query parameters are not support for group variable references")
-		}
-
 		return &GroupTokenReference{
-			url:   referenceUrl,
-			group: id,
-			key:   key,
+			url:              referenceUrl,
+			group:            id,
+			key:              key,
+			environmentScope: q.Get("environment"),
+			options:          options,
 		}, nil
 	}
 
-	if len(q) > 1 {
-		return nil, fmt.Errorf("multiple query parameters are not support for project variable references")
-	}
-	if environment, ok := q["environment"]; ok && len(environment) > 1 {
-		return nil, expectError
-	}
 	return &ProjectTokenReference{
 		url:              referenceUrl,
 		project:          id,
 		key:              key,
 		environmentScope: q.Get("environment"),
+		options:          options,
 	}, nil
 }
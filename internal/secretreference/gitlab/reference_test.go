@@ -0,0 +1,262 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeGitlab creates a stand-in for the Gitlab CI/CD variables API, which only accepts the token.
+func newFakeGitlab(t *testing.T, token string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/{project}/variables", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]any{map[string]any{"key": "GITLAB_TOKEN", "environment_scope": "*"}})
+	})
+	mux.HandleFunc("GET /api/v4/projects/{project}/variables/{key}", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"key": r.PathValue("key"), "value": "glpat-" + r.PathValue("project")})
+	})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Private-Token") != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	httpClient = server.Client()
+	t.Cleanup(func() {
+		server.Close()
+		httpClient = nil
+	})
+	return server
+}
+
+func TestTokenVariable(t *testing.T) {
+	if name := TokenVariable("gitlab.example.com"); name != "GITLAB_TOKEN_GITLAB_EXAMPLE_COM" {
+		t.Errorf("TokenVariable() = %s", name)
+	}
+	if name := TokenVariable("127.0.0.1:8443"); name != "GITLAB_TOKEN_127_0_0_1_8443" {
+		t.Errorf("TokenVariable() = %s", name)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	if expiresAt, err := parseExpiry("token of the bot, expires on 2024-06-01"); err != nil || !expiresAt.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("parseExpiry() = %s, %v", expiresAt, err)
+	}
+	if expiresAt, err := parseExpiry("token of the bot"); err != nil || !expiresAt.IsZero() {
+		t.Errorf("parseExpiry() without an expiry date = %s, %v", expiresAt, err)
+	}
+	if _, err := parseExpiry("token of the bot, expires on 2024-13-01"); err == nil {
+		t.Error("expected an error for an invalid expiry date")
+	}
+}
+
+func TestReadUsesHostAndHostToken(t *testing.T) {
+	server := newFakeGitlab(t, "glpat-admin")
+	host := server.Listener.Addr().String()
+	t.Setenv("GITLAB_TOKEN", "glpat-gitlab.com")
+	t.Setenv("GITLAB_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials.yaml"))
+
+	u, _ := url.Parse("gitlab://" + host + "/projects/42/variables/GITLAB_TOKEN")
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ref.Read(context.Background()); err == nil {
+		t.Fatal("expected GITLAB_TOKEN not to be sent to the host")
+	}
+
+	t.Setenv(TokenVariable(host), "glpat-admin")
+	if token, err := ref.Read(context.Background()); err != nil || token != "glpat-42" {
+		t.Errorf("Read() = %s, %v", token, err)
+	}
+}
+
+func TestHostTokenFromCredentialsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yaml")
+	content := "gitlab.example.com:\n  token: glpat-example\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITLAB_CREDENTIALS_FILE", path)
+	t.Setenv("GITLAB_TOKEN", "glpat-default")
+	t.Setenv(TokenVariable("gitlab.example.com"), "")
+
+	if token, err := hostToken("gitlab.example.com"); err != nil || token != "glpat-example" {
+		t.Errorf("hostToken() = %s, %v", token, err)
+	}
+	if token, err := hostToken("gitlab.com"); err != nil || token != "glpat-default" {
+		t.Errorf("hostToken() = %s, %v", token, err)
+	}
+	if token, err := hostToken("gitlab.internal"); err == nil || !strings.Contains(err.Error(), "no token configured") {
+		t.Errorf("hostToken() expected GITLAB_TOKEN not to be used for another host, got %s, %v", token, err)
+	}
+
+	t.Setenv("GITLAB_TOKEN", "")
+	if _, err := hostToken("gitlab.com"); err == nil {
+		t.Error("hostToken() expected an error without a token for gitlab.com")
+	}
+
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := hostToken("gitlab.example.com"); err == nil {
+		t.Errorf("hostToken() expected an error for a credentials file readable by others, got %s", token)
+	}
+}
+
+// recordBody handles a request by decoding its json body into the map.
+func recordBody(t *testing.T, body map[string]any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid request body, %v", err)
+		}
+		body["filter"] = r.URL.Query().Get("filter[environment_scope]")
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+func TestNewFromURLQueryParameters(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"gitlab://gitlab.com/projects/42/variables/TOKEN?environment=production&masked=false&format=json", false},
+		{"gitlab://gitlab.com/groups/7/variables/TOKEN?environment=production&raw=true", false},
+		{"gitlab://gitlab.com/admin/variables/TOKEN?variable_type=file&description=deploy", false},
+		{"gitlab://gitlab.com/admin/variables/TOKEN?environment=production", true},
+		{"gitlab://gitlab.com/projects/42/variables/TOKEN?masked=maybe", true},
+		{"gitlab://gitlab.com/projects/42/variables/TOKEN?variable_type=secret", true},
+		{"gitlab://gitlab.com/projects/42/variables/TOKEN?environment=a&environment=b", true},
+		{"gitlab://gitlab.com/groups/7/variables/TOKEN?scope=production", true},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if _, err := NewFromURL(context.Background(), u); (err != nil) != tt.wantErr {
+			t.Errorf("NewFromURL(%s) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUpdateCreatesMissingVariable(t *testing.T) {
+	server := newFakeGitlab(t, "glpat-admin")
+	created := map[string]any{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/42/variables", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]any{})
+	})
+	mux.HandleFunc("GET /api/v4/projects/42/variables/TOKEN", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "404 Variable Not Found"}`))
+	})
+	mux.HandleFunc("POST /api/v4/projects/42/variables", recordBody(t, created))
+	server.Config.Handler = mux
+	host := server.Listener.Addr().String()
+	t.Setenv(TokenVariable(host), "glpat-admin")
+
+	u, _ := url.Parse("gitlab://" + host + "/projects/42/variables/TOKEN?masked=false&variable_type=file&description=deploy+token")
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ref.Update(context.Background(), "glpat-new", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"key":               "TOKEN",
+		"value":             "glpat-new",
+		"description":       "deploy token, expires on 2025-01-31",
+		"environment_scope": "*",
+		"masked":            false,
+		"protected":         true,
+		"variable_type":     "file",
+	}
+	for name, value := range want {
+		if created[name] != value {
+			t.Errorf("created %s = %v, want %v", name, created[name], value)
+		}
+	}
+}
+
+func TestUpdateGroupVariableInEnvironment(t *testing.T) {
+	server := newFakeGitlab(t, "glpat-admin")
+	updated := map[string]any{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/groups/7/variables/TOKEN", func(w http.ResponseWriter, r *http.Request) {
+		if scope := r.URL.Query().Get("filter[environment_scope]"); scope != "production" {
+			t.Errorf("read variable in environment %q", scope)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"key": "TOKEN", "value": "glpat-old",
+			"environment_scope": "production", "description": "ci token, expires on 2024-01-01"})
+	})
+	mux.HandleFunc("PUT /api/v4/groups/7/variables/TOKEN", recordBody(t, updated))
+	server.Config.Handler = mux
+	host := server.Listener.Addr().String()
+	t.Setenv(TokenVariable(host), "glpat-admin")
+
+	u, _ := url.Parse("gitlab://" + host + "/groups/7/variables/TOKEN?environment=production&protected=false")
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ref.Read(context.Background()); err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+	if err = ref.Update(context.Background(), "glpat-new", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"value":             "glpat-new",
+		"description":       "ci token, expires on 2025-01-31",
+		"environment_scope": "production",
+		"protected":         false,
+		"filter":            "production",
+	}
+	for name, value := range want {
+		if updated[name] != value {
+			t.Errorf("updated %s = %v, want %v", name, updated[name], value)
+		}
+	}
+	if _, ok := updated["masked"]; ok {
+		t.Errorf("masked was updated, although not specified")
+	}
+}
+
+func TestInstanceVariable(t *testing.T) {
+	server := newFakeGitlab(t, "glpat-admin")
+	updated := map[string]any{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/admin/ci/variables/TOKEN", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"key": "TOKEN", "value": "glpat-old",
+			"description": "token expires on 2024-01-01"})
+	})
+	mux.HandleFunc("PUT /api/v4/admin/ci/variables/TOKEN", recordBody(t, updated))
+	server.Config.Handler = mux
+	host := server.Listener.Addr().String()
+	t.Setenv(TokenVariable(host), "glpat-admin")
+
+	u, _ := url.Parse("gitlab://" + host + "/admin/variables/TOKEN?raw=true")
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ref.Read(context.Background()); err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+	if err = ref.Update(context.Background(), "glpat-new", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if updated["value"] != "glpat-new" || updated["raw"] != true || updated["description"] != "token expires on 2025-01-31" {
+		t.Errorf("updated %v", updated)
+	}
+}
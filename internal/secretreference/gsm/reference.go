@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,27 +19,57 @@ import (
 
 	"github.com/binxio/gcloudconfig"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
+// ExpiresAtAnnotation is the annotation on the secret holding the expiry date of the latest token.
+const ExpiresAtAnnotation = "token-manager-expires-at"
+
+// ImpersonateParameter is the query parameter with the service account to impersonate.
+const ImpersonateParameter = "impersonate-service-account"
+
+// UseDefaultCredentialsParameter is the query parameter forcing the use of the application default
+// credentials, instead of the credentials of gcloud.
+const UseDefaultCredentialsParameter = "use-default-credentials"
+
 type TokenReference struct {
+	reference             string
 	secretName            string
 	secretVersion         string
 	project               string
 	useDefaultCredentials bool
+	keepVersions          int
 	client                *secretmanager.Client
 }
 
+// clientOptions are the options for the Google Secret Manager client of a reference.
+type clientOptions struct {
+	useDefaultCredentials     bool
+	impersonateServiceAccount string
+}
+
 func (t TokenReference) String() string {
+	if t.reference != "" {
+		return t.reference
+	}
 	return fmt.Sprintf("gsm:///%s", t.secretName)
 }
 
 // NewTokenReference create a new Google Secret Manager token reference
 func NewTokenReference(ctx context.Context, secretName string, project string, useDefaultCredentials bool) (secretreference.SecretReference, error) {
+	return newTokenReference(ctx, secretName, project, clientOptions{useDefaultCredentials: useDefaultCredentials})
+}
+
+func newTokenReference(ctx context.Context, secretName string, project string, options clientOptions) (*TokenReference, error) {
 	var err error
 	var credentials *google.Credentials
 
-	if useDefaultCredentials || !gcloudconfig.IsGCloudOnPath() {
+	if options.useDefaultCredentials || !gcloudconfig.IsGCloudOnPath() {
 		credentials, err = google.FindDefaultCredentials(ctx)
 	} else {
 		credentials, err = gcloudconfig.GetCredentials("")
@@ -48,18 +81,37 @@ func NewTokenReference(ctx context.Context, secretName string, project string, u
 	if project == "" {
 		project = credentials.ProjectID
 	}
-	if project == "" {
+	if project == "" && !strings.HasPrefix(secretName, "projects/") {
 		return nil, fmt.Errorf("no google project defined")
 	}
 
 	var ref TokenReference
-	ref.client, err = secretmanager.NewClient(ctx, option.WithCredentials(credentials))
+	ref.secretName = secretName
+	ref.project = project
+	ref.useDefaultCredentials = options.useDefaultCredentials
+	ref.secretVersion, err = normalizeSecretName(secretName, project)
 	if err != nil {
 		return nil, err
 	}
 
-	ref.secretName = secretName
-	ref.secretVersion, err = normalizeSecretName(secretName, project)
+	clientOption := option.WithCredentials(credentials)
+	if options.impersonateServiceAccount != "" {
+		tokenSource, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: options.impersonateServiceAccount,
+			Scopes:          secretmanager.DefaultAuthScopes(),
+		}, clientOption)
+		if err != nil {
+			return nil, err
+		}
+		clientOption = option.WithTokenSource(tokenSource)
+	}
+
+	clientOptions := []option.ClientOption{clientOption}
+	if location := ref.location(); location != "" {
+		// regional secrets are only available through the regional endpoint
+		clientOptions = append(clientOptions, option.WithEndpoint(fmt.Sprintf("secretmanager.%s.rep.googleapis.com:443", location)))
+	}
+	ref.client, err = secretmanager.NewClient(ctx, clientOptions...)
 	if err != nil {
 		return nil, err
 	}
@@ -67,14 +119,72 @@ func NewTokenReference(ctx context.Context, secretName string, project string, u
 	return &ref, nil
 }
 
+// NewFromURL creates a reference to a Google Secret Manager secret from an URL in the form
+// gsm:///<secret>, gsm://<project>/<secret>[/<version>] or
+// gsm://<project>/locations/<location>/secrets/<secret>[/versions/<version>].
 func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	secretName, options, err := parseURL(referenceURL)
+	if err != nil {
+		return nil, err
+	}
+	keepVersions, err := secretreference.ParseKeepVersions(referenceURL.Query())
+	if err != nil {
+		return nil, err
+	}
+	ref, err := newTokenReference(ctx, secretName, "", options)
+	if err != nil {
+		return nil, err
+	}
+	ref.reference = referenceURL.String()
+	ref.keepVersions = keepVersions
+	return ref, nil
+}
+
+// secretPattern matches the path of a gsm URL with a project host.
+var secretPattern = regexp.MustCompile(`^(locations/[^/]+/)?secrets/[^/]+(/versions/[^/]+)?$`)
+
+// parseURL returns the secret name and the client options from the gsm URL. With a project host,
+// the secret name is the full resource name of the secret version.
+func parseURL(referenceURL *url.URL) (string, clientOptions, error) {
+	var options clientOptions
 	if referenceURL.Scheme != "gsm" {
-		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+		return "", options, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
 	}
-	if referenceURL.Host != "" {
-		return nil, errors.New("expected an url in the form gsm:///<google secret name>")
+
+	query := referenceURL.Query()
+	options.impersonateServiceAccount = query.Get(ImpersonateParameter)
+	if value := query.Get(UseDefaultCredentialsParameter); value != "" {
+		var err error
+		if options.useDefaultCredentials, err = strconv.ParseBool(value); err != nil {
+			return "", options, fmt.Errorf("invalid %s value %s", UseDefaultCredentialsParameter, value)
+		}
+	}
+
+	path := strings.TrimPrefix(referenceURL.Path, "/")
+	if referenceURL.Host == "" {
+		if path == "" {
+			return "", options, errors.New("expected an url in the form gsm:///<google secret name>")
+		}
+		return path, options, nil
+	}
+
+	name := "projects/" + referenceURL.Host + "/"
+	parts := strings.Split(path, "/")
+	switch {
+	case secretPattern.MatchString(path):
+		name += path
+	case len(parts) == 1 && parts[0] != "":
+		name += "secrets/" + parts[0]
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		name += "secrets/" + parts[0] + "/versions/" + parts[1]
+	default:
+		return "", options, errors.New("expected an url in the form gsm://<project>/<secret>[/<version>] or " +
+			"gsm://<project>/locations/<location>/secrets/<secret>[/versions/<version>]")
 	}
-	return NewTokenReference(ctx, referenceURL.Path[1:], "", false)
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	return name, options, nil
 }
 
 // Read reads the token from an Google Secret Manager secret
@@ -84,32 +194,248 @@ func (t TokenReference) Read(ctx context.Context) (string, error) {
 	}
 
 	response, err := t.client.AccessSecretVersion(ctx, request)
-	if err != nil {
+	if status.Code(err) == codes.NotFound {
+		return "", fmt.Errorf("%s, %w", err, secretreference.ErrNotFound)
+	} else if err != nil {
 		return "", err
 	}
 
 	return string(response.Payload.Data), nil
 }
 
-// Update updates the secret of the Google Secret Manager secret
+// ReadWithVersion reads the token from the Google Secret Manager secret, and the etag of the secret.
+func (t TokenReference) ReadWithVersion(ctx context.Context) (string, string, error) {
+	secret, err := t.client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{Name: t.parent()})
+	if err != nil {
+		return "", "", err
+	}
+	token, err := t.Read(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	return token, secret.Etag, nil
+}
+
+// Update updates the secret of the Google Secret Manager secret, and annotates the secret with the expiry date
 func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
-	parent := t.secretVersion[:strings.Index(t.secretVersion, "/versions/")]
+	return t.UpdateIfVersion(ctx, token, expiresAt, "")
+}
+
+// UpdateIfVersion updates the secret like Update, if the etag of the secret still matches. With an
+// etag, the annotation is updated first, so that a concurrent update fails before adding a secret
+// version. Without an etag, the version is added first and the annotation is updated on a best effort
+// basis, so that a missing permission to update the secret does not block the token write.
+func (t TokenReference) UpdateIfVersion(ctx context.Context, token string, expiresAt time.Time, version string) error {
+	if version == "" {
+		if err := t.addVersion(ctx, token); err != nil {
+			return err
+		}
+		if _, err := t.annotate(ctx, expiresAt.Format(time.RFC3339), ""); err != nil {
+			log.Printf("failed to annotate %s with the expiry date, %s", t, err)
+		}
+		return nil
+	}
+
+	previous, err := t.annotate(ctx, expiresAt.Format(time.RFC3339), version)
+	if code := status.Code(err); code == codes.Aborted || code == codes.FailedPrecondition {
+		return fmt.Errorf("%s, %w", err, secretreference.ErrConflict)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = t.addVersion(ctx, token); err != nil {
+		if _, restoreErr := t.annotate(ctx, previous, ""); restoreErr != nil {
+			log.Printf("failed to restore the expiry date annotation of %s, %s", t, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
 
-	request := &secretmanagerpb.AddSecretVersionRequest{
-		Parent:  parent,
+// addVersion adds a version holding the token to the secret.
+func (t TokenReference) addVersion(ctx context.Context, token string) error {
+	_, err := t.client.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+		Parent:  t.parent(),
 		Payload: &secretmanagerpb.SecretPayload{Data: []byte(token)},
+	})
+	return err
+}
+
+// annotate sets the expiry date annotation of the secret to the value, or removes it if the value is
+// empty. With an etag, the update fails if the secret was changed since. It returns the previous value.
+func (t TokenReference) annotate(ctx context.Context, value, etag string) (string, error) {
+	secret, err := t.client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{Name: t.parent()})
+	if err != nil {
+		return "", err
+	}
+	previous := secret.Annotations[ExpiresAtAnnotation]
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	if value == "" {
+		delete(secret.Annotations, ExpiresAtAnnotation)
+	} else {
+		secret.Annotations[ExpiresAtAnnotation] = value
 	}
+	if etag != "" {
+		secret.Etag = etag
+	}
+
+	_, err = t.client.UpdateSecret(ctx, &secretmanagerpb.UpdateSecretRequest{
+		Secret:     secret,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"annotations"}},
+	})
+	return previous, err
+}
 
-	_, err := t.client.AddSecretVersion(ctx, request)
+// Create creates the secret with automatic replication, annotated with the expiry date, and adds
+// the token as the first version. A regional secret is created in its location, without replication.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	name := t.parent()
+	secretsIndex := strings.Index(name, "/secrets/")
+	secret := &secretmanagerpb.Secret{
+		Annotations: map[string]string{ExpiresAtAnnotation: expiresAt.Format(time.RFC3339)},
+	}
+	if t.location() == "" {
+		secret.Replication = &secretmanagerpb.Replication{
+			Replication: &secretmanagerpb.Replication_Automatic_{Automatic: &secretmanagerpb.Replication_Automatic{}},
+		}
+	}
+	_, err := t.client.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{
+		Parent:   name[:secretsIndex],
+		SecretId: name[secretsIndex+len("/secrets/"):],
+		Secret:   secret,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = t.client.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+		Parent:  name,
+		Payload: &secretmanagerpb.SecretPayload{Data: []byte(token)},
+	})
 	return err
 }
 
-// normalizeSecretName normalizes the Google Secret Manager secret name to "projects/[^/]+/secrets/[^/]+/.*"
+// Metadata reads the version and creation date of the secret version, and the expiry date from the
+// annotations of the secret.
+func (t TokenReference) Metadata(ctx context.Context) (secretreference.Metadata, error) {
+	var metadata secretreference.Metadata
+	version, err := t.client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: t.secretVersion})
+	if err != nil {
+		return metadata, err
+	}
+	metadata.Version = version.Name[strings.LastIndex(version.Name, "/")+1:]
+	metadata.UpdatedAt = version.CreateTime.AsTime()
+
+	secret, err := t.client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{Name: t.parent()})
+	if err != nil {
+		return metadata, err
+	}
+	if expiresAt, ok := secret.Annotations[ExpiresAtAnnotation]; ok {
+		if metadata.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+			return metadata, fmt.Errorf("invalid %s annotation on %s: %w", ExpiresAtAnnotation, t, err)
+		}
+	}
+	return metadata, nil
+}
+
+// Versions returns the enabled versions of the secret, newest first.
+func (t TokenReference) Versions(ctx context.Context) ([]secretreference.Version, error) {
+	var versions []secretreference.Version
+	it := t.client.ListSecretVersions(ctx, &secretmanagerpb.ListSecretVersionsRequest{
+		Parent: t.parent(),
+		Filter: "state:ENABLED",
+	})
+	for {
+		version, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, secretreference.Version{
+			ID:        version.Name[strings.LastIndex(version.Name, "/")+1:],
+			CreatedAt: version.CreateTime.AsTime(),
+		})
+	}
+	slices.SortFunc(versions, func(a, b secretreference.Version) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	current := t.secretVersion[strings.LastIndex(t.secretVersion, "/")+1:]
+	for i := range versions {
+		versions[i].Current = versions[i].ID == current || (current == "latest" && i == 0)
+	}
+	return versions, nil
+}
+
+// ReadVersion reads the token from the version of the secret
+func (t TokenReference) ReadVersion(ctx context.Context, id string) (string, error) {
+	response, err := t.client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{
+		Name: t.parent() + "/versions/" + id,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(response.Payload.Data), nil
+}
+
+// KeepVersions returns the number of versions to keep, from the keep-versions query parameter.
+func (t TokenReference) KeepVersions() int {
+	return t.keepVersions
+}
+
+// Prune destroys the enabled versions of the secret, except the newest keep versions and the current one.
+func (t TokenReference) Prune(ctx context.Context, keep int) error {
+	versions, err := t.Versions(ctx)
+	if err != nil {
+		return err
+	}
+	for i, version := range versions {
+		if i < keep || version.Current {
+			continue
+		}
+		_, err = t.client.DestroySecretVersion(ctx, &secretmanagerpb.DestroySecretVersionRequest{
+			Name: t.parent() + "/versions/" + version.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parent returns the name of the secret of the version
+func (t TokenReference) parent() string {
+	return t.secretVersion[:strings.Index(t.secretVersion, "/versions/")]
+}
+
+// location returns the location of a regional secret, or an empty string for a global secret.
+func (t TokenReference) location() string {
+	if match := locationPattern.FindStringSubmatch(t.secretVersion); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+var (
+	locationPattern     = regexp.MustCompile(`^projects/[^/]+/locations/([^/]+)/secrets/`)
+	resourceNamePattern = regexp.MustCompile(`^projects/[^/]+/(locations/[^/]+/)?secrets/[^/]+(/versions/[^/]+)?$`)
+)
+
+// normalizeSecretName normalizes the Google Secret Manager secret name to
+// "projects/[^/]+/[locations/[^/]+/]secrets/[^/]+/versions/.*"
 func normalizeSecretName(secretName string, project string) (string, error) {
 	var name string
 	var version string
 
-	if match, _ := regexp.MatchString("projects/[^/]+/secrets/[^/]+/.*", secretName); match {
+	if resourceNamePattern.MatchString(secretName) {
+		if !strings.Contains(secretName, "/versions/") {
+			secretName += "/versions/latest"
+		}
 		return secretName, nil
 	}
 
@@ -119,7 +445,7 @@ func normalizeSecretName(secretName string, project string) (string, error) {
 		name = parts[0]
 		version = "latest"
 	case 2:
-		if match, _ := regexp.MatchString("([0-9]+|latest)", parts[1]); match {
+		if match, _ := regexp.MatchString("^([0-9]+|latest)$", parts[1]); match {
 			name = parts[0]
 			version = parts[1]
 		} else {
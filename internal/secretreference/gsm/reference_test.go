@@ -0,0 +1,354 @@
+package gsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"token-manager/internal/secretreference"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url         string
+		name        string
+		location    string
+		options     clientOptions
+		expectError bool
+	}{
+		{url: "gsm:///gitlab-pat", name: "projects/default/secrets/gitlab-pat/versions/latest"},
+		{url: "gsm:///gitlab-pat/3", name: "projects/default/secrets/gitlab-pat/versions/3"},
+		{url: "gsm:///projects/central/secrets/gitlab-pat", name: "projects/central/secrets/gitlab-pat/versions/latest"},
+		{url: "gsm://central/gitlab-pat", name: "projects/central/secrets/gitlab-pat/versions/latest"},
+		{url: "gsm://central/gitlab-pat/7", name: "projects/central/secrets/gitlab-pat/versions/7"},
+		{url: "gsm://central/secrets/gitlab-pat/versions/7", name: "projects/central/secrets/gitlab-pat/versions/7"},
+		{
+			url:      "gsm://central/locations/europe-west4/secrets/gitlab-pat",
+			name:     "projects/central/locations/europe-west4/secrets/gitlab-pat/versions/latest",
+			location: "europe-west4",
+		},
+		{
+			url:      "gsm:///projects/central/locations/europe-west4/secrets/gitlab-pat/versions/2",
+			name:     "projects/central/locations/europe-west4/secrets/gitlab-pat/versions/2",
+			location: "europe-west4",
+		},
+		{
+			url:     "gsm://central/gitlab-pat?use-default-credentials=true&impersonate-service-account=sa@central.iam.gserviceaccount.com",
+			name:    "projects/central/secrets/gitlab-pat/versions/latest",
+			options: clientOptions{useDefaultCredentials: true, impersonateServiceAccount: "sa@central.iam.gserviceaccount.com"},
+		},
+		{url: "gsm://central", expectError: true},
+		{url: "gsm://central/a/b/c", expectError: true},
+		{url: "gsm:///gitlab-pat?use-default-credentials=maybe", expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			secretName, options, err := parseURL(u)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("parseURL() error = %v, expected error %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+			name, err := normalizeSecretName(secretName, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.name {
+				t.Errorf("expected secret name %s, got %s", tt.name, name)
+			}
+			if location := (TokenReference{secretVersion: name}).location(); location != tt.location {
+				t.Errorf("expected location %s, got %s", tt.location, location)
+			}
+			if options != tt.options {
+				t.Errorf("expected options %+v, got %+v", tt.options, options)
+			}
+		})
+	}
+}
+
+// fakeSecretManager is a stand-in for the Secret Manager API, holding a single secret.
+type fakeSecretManager struct {
+	secretmanagerpb.UnimplementedSecretManagerServiceServer
+	mutex      sync.Mutex
+	secret     *secretmanagerpb.Secret
+	versions   []*secretmanagerpb.SecretVersion
+	data       map[string]string
+	calls      []string
+	failUpdate error
+	failAdd    error
+}
+
+// newFakeReference creates a reference to the latest version of the secret gitlab-pat in a fake Secret Manager.
+func newFakeReference(t *testing.T) (*TokenReference, *fakeSecretManager) {
+	fake := &fakeSecretManager{
+		secret: &secretmanagerpb.Secret{Name: "projects/central/secrets/gitlab-pat", Etag: `"1"`},
+		data:   make(map[string]string),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	secretmanagerpb.RegisterSecretManagerServiceServer(server, fake)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	client, err := secretmanager.NewClient(context.Background(),
+		option.WithEndpoint(listener.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return &TokenReference{
+		secretName:    "gitlab-pat",
+		secretVersion: "projects/central/secrets/gitlab-pat/versions/latest",
+		project:       "central",
+		client:        client,
+	}, fake
+}
+
+// add adds an enabled version holding the token to the secret.
+func (f *fakeSecretManager) add(token string) *secretmanagerpb.SecretVersion {
+	version := &secretmanagerpb.SecretVersion{
+		Name:       fmt.Sprintf("%s/versions/%d", f.secret.Name, len(f.versions)+1),
+		CreateTime: timestamppb.New(time.Date(2024, 1, 1, 0, len(f.versions), 0, 0, time.UTC)),
+		State:      secretmanagerpb.SecretVersion_ENABLED,
+	}
+	f.versions = append(f.versions, version)
+	f.data[version.Name] = token
+	return version
+}
+
+// find returns the version with the name, resolving the latest alias to the newest enabled version.
+func (f *fakeSecretManager) find(name string) (*secretmanagerpb.SecretVersion, error) {
+	for i := len(f.versions) - 1; i >= 0; i-- {
+		version := f.versions[i]
+		if version.Name == name || (strings.HasSuffix(name, "/latest") && version.State == secretmanagerpb.SecretVersion_ENABLED) {
+			return version, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "secret version %s not found", name)
+}
+
+func (f *fakeSecretManager) call(name string) {
+	f.calls = append(f.calls, name)
+}
+
+func (f *fakeSecretManager) GetSecret(_ context.Context, _ *secretmanagerpb.GetSecretRequest) (*secretmanagerpb.Secret, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.call("GetSecret")
+	return proto.Clone(f.secret).(*secretmanagerpb.Secret), nil
+}
+
+func (f *fakeSecretManager) UpdateSecret(_ context.Context, request *secretmanagerpb.UpdateSecretRequest) (*secretmanagerpb.Secret, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.call("UpdateSecret")
+	if f.failUpdate != nil {
+		return nil, f.failUpdate
+	}
+	if request.Secret.Etag != "" && request.Secret.Etag != f.secret.Etag {
+		return nil, status.Errorf(codes.Aborted, "etag %s does not match %s", request.Secret.Etag, f.secret.Etag)
+	}
+	f.secret.Annotations = request.Secret.Annotations
+	f.secret.Etag = fmt.Sprintf(`"%d"`, len(f.calls))
+	return proto.Clone(f.secret).(*secretmanagerpb.Secret), nil
+}
+
+func (f *fakeSecretManager) AddSecretVersion(_ context.Context, request *secretmanagerpb.AddSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.call("AddSecretVersion")
+	if f.failAdd != nil {
+		return nil, f.failAdd
+	}
+	return f.add(string(request.Payload.Data)), nil
+}
+
+func (f *fakeSecretManager) AccessSecretVersion(_ context.Context, request *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	version, err := f.find(request.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name:    version.Name,
+		Payload: &secretmanagerpb.SecretPayload{Data: []byte(f.data[version.Name])},
+	}, nil
+}
+
+func (f *fakeSecretManager) GetSecretVersion(_ context.Context, request *secretmanagerpb.GetSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.find(request.Name)
+}
+
+func (f *fakeSecretManager) ListSecretVersions(_ context.Context, request *secretmanagerpb.ListSecretVersionsRequest) (*secretmanagerpb.ListSecretVersionsResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	response := &secretmanagerpb.ListSecretVersionsResponse{}
+	for _, version := range f.versions {
+		if request.Filter != "state:ENABLED" || version.State == secretmanagerpb.SecretVersion_ENABLED {
+			response.Versions = append(response.Versions, version)
+		}
+	}
+	return response, nil
+}
+
+func (f *fakeSecretManager) DestroySecretVersion(_ context.Context, request *secretmanagerpb.DestroySecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	version, err := f.find(request.Name)
+	if err != nil {
+		return nil, err
+	}
+	version.State = secretmanagerpb.SecretVersion_DESTROYED
+	return version, nil
+}
+
+func TestUpdateAddsVersionBeforeAnnotating(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	fake.add("glpat-old")
+	fake.failUpdate = status.Error(codes.PermissionDenied, "secretmanager.secrets.update denied")
+
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatalf("expected a failed annotation not to fail the update, got %v", err)
+	}
+	if token, err := ref.Read(ctx); err != nil || token != "glpat-new" {
+		t.Errorf("Read() = %s, %v", token, err)
+	}
+	if strings.Join(fake.calls, ",") != "AddSecretVersion,GetSecret,UpdateSecret" {
+		t.Errorf("expected the version to be added before annotating, got %v", fake.calls)
+	}
+
+	fake.failUpdate = nil
+	if err := ref.Update(ctx, "glpat-newer", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := ref.Metadata(ctx)
+	if err != nil || !metadata.ExpiresAt.Equal(expiresAt) || metadata.Version != "3" {
+		t.Errorf("Metadata() = %+v, %v", metadata, err)
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	fake.add("glpat-old")
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	_, etag, err := ref.ReadWithVersion(ctx)
+	if err != nil || etag == "" {
+		t.Fatalf("ReadWithVersion() = %s, %v", etag, err)
+	}
+
+	// another rotation writes its token, after this one read the secret
+	if err = ref.Update(ctx, "glpat-winner", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err = ref.UpdateIfVersion(ctx, "glpat-loser", expiresAt, etag); !errors.Is(err, secretreference.ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if token, _ := ref.Read(ctx); token != "glpat-winner" || len(fake.versions) != 2 {
+		t.Errorf("expected no version to be added by the loser, got %s in %d versions", token, len(fake.versions))
+	}
+
+	_, etag, _ = ref.ReadWithVersion(ctx)
+	if err = ref.UpdateIfVersion(ctx, "glpat-new", expiresAt, etag); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := ref.Read(ctx); token != "glpat-new" {
+		t.Errorf("Read() after UpdateIfVersion() = %s", token)
+	}
+}
+
+func TestUpdateIfVersionRestoresAnnotationOnFailedAdd(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	fake.add("glpat-old")
+	fake.secret.Annotations = map[string]string{ExpiresAtAnnotation: "2024-05-01T00:00:00Z"}
+
+	_, etag, err := ref.ReadWithVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.failAdd = status.Error(codes.ResourceExhausted, "too many versions")
+	if err = ref.UpdateIfVersion(ctx, "glpat-new", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), etag); err == nil {
+		t.Fatal("expected the failed version to be returned")
+	}
+	if expiresAt := fake.secret.Annotations[ExpiresAtAnnotation]; expiresAt != "2024-05-01T00:00:00Z" {
+		t.Errorf("expected the expiry date annotation to be restored, got %s", expiresAt)
+	}
+}
+
+func TestReadMissingVersion(t *testing.T) {
+	ref, _ := newFakeReference(t)
+	if _, err := ref.Read(context.Background()); !errors.Is(err, secretreference.ErrNotFound) {
+		t.Errorf("Read() expected not found for a secret without versions, got %v", err)
+	}
+}
+
+func TestMetadataRejectsInvalidExpiry(t *testing.T) {
+	ref, fake := newFakeReference(t)
+	fake.add("glpat-old")
+	fake.secret.Annotations = map[string]string{ExpiresAtAnnotation: "next week"}
+	if _, err := ref.Metadata(context.Background()); err == nil {
+		t.Error("expected an error for an invalid expiry date annotation")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		version   string
+		destroyed []string
+	}{
+		{version: "latest", destroyed: []string{"1", "2"}},
+		{version: "1", destroyed: []string{"2"}},
+	} {
+		t.Run(tt.version, func(t *testing.T) {
+			ref, fake := newFakeReference(t)
+			for i := 1; i <= 4; i++ {
+				fake.add(fmt.Sprintf("glpat-%d", i))
+			}
+			ref.secretVersion = "projects/central/secrets/gitlab-pat/versions/" + tt.version
+
+			if err := ref.Prune(ctx, 2); err != nil {
+				t.Fatal(err)
+			}
+			var destroyed []string
+			for _, version := range fake.versions {
+				if version.State == secretmanagerpb.SecretVersion_DESTROYED {
+					destroyed = append(destroyed, version.Name[strings.LastIndex(version.Name, "/")+1:])
+				}
+			}
+			if strings.Join(destroyed, ",") != strings.Join(tt.destroyed, ",") {
+				t.Errorf("expected versions %v to be destroyed, got %v", tt.destroyed, destroyed)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"token-manager/internal/secretreference"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ExpiresAtAnnotation is the annotation on the secret holding the expiry date of the token.
+const ExpiresAtAnnotation = "token-manager/expires-at"
+
+type TokenReference struct {
+	kubeContext string
+	namespace   string
+	secretName  string
+	key         string
+	client      func() (kubernetes.Interface, error)
+}
+
+func (t TokenReference) String() string {
+	u := url.URL{Scheme: "k8s", Host: t.kubeContext, Path: fmt.Sprintf("/%s/%s/%s", t.namespace, t.secretName, t.key)}
+	return u.String()
+}
+
+// NewTokenReference create a new Kubernetes secret token reference. If no kubeContext is specified,
+// the in-cluster configuration is used when running in a pod, otherwise the current context.
+func NewTokenReference(_ context.Context, kubeContext, namespace, secretName, key string) (*TokenReference, error) {
+	if namespace == "" || secretName == "" || key == "" {
+		return nil, errors.New("a namespace, secret name and key are required")
+	}
+	return &TokenReference{
+		kubeContext: kubeContext,
+		namespace:   namespace,
+		secretName:  secretName,
+		key:         key,
+		client: sync.OnceValues(func() (kubernetes.Interface, error) {
+			config, err := restConfig(kubeContext)
+			if err != nil {
+				return nil, err
+			}
+			return kubernetes.NewForConfig(config)
+		}),
+	}, nil
+}
+
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	// k8s://kind-kind/argocd/repo-creds/password
+	if referenceURL.Scheme != "k8s" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	parts := strings.Split(strings.TrimPrefix(referenceURL.Path, "/"), "/")
+	if len(parts) != 3 {
+		return nil, errors.New("expected an url in the form k8s://<context>/<namespace>/<secret>/<key>")
+	}
+	return NewTokenReference(ctx, referenceURL.Host, parts[0], parts[1], parts[2])
+}
+
+// restConfig returns the configuration for the kubeContext from the kubeconfig, or the
+// in-cluster configuration if no context is specified and the process runs in a pod.
+func restConfig(kubeContext string) (*rest.Config, error) {
+	if kubeContext == "" {
+		if config, err := rest.InClusterConfig(); err == nil {
+			return config, nil
+		}
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).ClientConfig()
+}
+
+// Read reads the token from the key of the Kubernetes secret
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	token, _, err := t.ReadWithVersion(ctx)
+	return token, err
+}
+
+// ReadWithVersion reads the token from the key of the Kubernetes secret, and the resourceVersion of the secret.
+func (t TokenReference) ReadWithVersion(ctx context.Context) (string, string, error) {
+	client, err := t.client()
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := client.CoreV1().Secrets(t.namespace).Get(ctx, t.secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", "", fmt.Errorf("%s, %w", err, secretreference.ErrNotFound)
+	} else if err != nil {
+		return "", "", err
+	}
+	value, ok := secret.Data[t.key]
+	if !ok {
+		return "", "", fmt.Errorf("no key %s found in secret %s/%s", t.key, t.namespace, t.secretName)
+	}
+	return string(value), secret.ResourceVersion, nil
+}
+
+// Update patches the key of the Kubernetes secret with the token and annotates the secret
+// with the expiry date. Other keys, labels and annotations are retained.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	return t.UpdateIfVersion(ctx, token, expiresAt, "")
+}
+
+// UpdateIfVersion patches the Kubernetes secret like Update, if the secret still has the resourceVersion.
+func (t TokenReference) UpdateIfVersion(ctx context.Context, token string, expiresAt time.Time, version string) error {
+	client, err := t.client()
+	if err != nil {
+		return err
+	}
+	metadata := map[string]any{
+		"annotations": map[string]string{ExpiresAtAnnotation: expiresAt.Format(time.RFC3339)},
+	}
+	if version != "" {
+		metadata["resourceVersion"] = version
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": metadata,
+		"data":     map[string][]byte{t.key: []byte(token)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Secrets(t.namespace).Patch(ctx, t.secretName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if apierrors.IsConflict(err) {
+		return fmt.Errorf("%s, %w", err, secretreference.ErrConflict)
+	}
+	return err
+}
+
+// Create creates an Opaque Kubernetes secret with the token in the key, annotated with the expiry date.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	client, err := t.client()
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        t.secretName,
+			Namespace:   t.namespace,
+			Annotations: map[string]string{ExpiresAtAnnotation: expiresAt.Format(time.RFC3339)},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{t.key: []byte(token)},
+	}
+	_, err = client.CoreV1().Secrets(t.namespace).Create(ctx, secret, metav1.CreateOptions{})
+	return err
+}
+
+// Metadata reads the expiry date from the annotation of the Kubernetes secret. The version is
+// the resourceVersion of the secret, and the update time the last time a field manager changed it.
+func (t TokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	client, err := t.client()
+	if err != nil {
+		return metadata, err
+	}
+	secret, err := client.CoreV1().Secrets(t.namespace).Get(ctx, t.secretName, metav1.GetOptions{})
+	if err != nil {
+		return metadata, err
+	}
+
+	metadata.Version = secret.ResourceVersion
+	metadata.UpdatedAt = secret.CreationTimestamp.Time
+	for _, field := range secret.ManagedFields {
+		if field.Time != nil && field.Time.After(metadata.UpdatedAt) {
+			metadata.UpdatedAt = field.Time.Time
+		}
+	}
+	if expiresAt, ok := secret.Annotations[ExpiresAtAnnotation]; ok {
+		if metadata.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+			return metadata, fmt.Errorf("invalid %s annotation on %s: %w", ExpiresAtAnnotation, t, err)
+		}
+	}
+	return metadata, nil
+}
@@ -0,0 +1,134 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"token-manager/internal/secretreference"
+)
+
+func TestReadAndUpdate(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo-creds",
+			Namespace: "argocd",
+			Labels:    map[string]string{"argocd.argoproj.io/secret-type": "repo-creds"},
+		},
+		Data: map[string][]byte{"username": []byte("bot"), "password": []byte("glpat-old")},
+	})
+
+	u, _ := url.Parse("k8s://kind-kind/argocd/repo-creds/password")
+	ref, err := NewFromURL(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.(*TokenReference).String() != u.String() {
+		t.Errorf("String() = %s, expected %s", ref, u)
+	}
+	ref.(*TokenReference).client = func() (kubernetes.Interface, error) { return client, nil }
+
+	token, err := ref.Read(ctx)
+	if err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err = ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, _ := client.CoreV1().Secrets("argocd").Get(ctx, "repo-creds", metav1.GetOptions{})
+	if string(secret.Data["password"]) != "glpat-new" || string(secret.Data["username"]) != "bot" {
+		t.Errorf("unexpected secret data after Update(), %v", secret.Data)
+	}
+	if secret.Annotations[ExpiresAtAnnotation] != "2024-06-01T00:00:00Z" {
+		t.Errorf("expected expiry annotation, got %v", secret.Annotations)
+	}
+	if secret.Labels["argocd.argoproj.io/secret-type"] != "repo-creds" {
+		t.Errorf("Update() did not retain labels, got %v", secret.Labels)
+	}
+
+	metadata, err := ref.(*TokenReference).Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Metadata() = %+v", metadata)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	ref, _ := NewTokenReference(ctx, "", "argocd", "repo-creds", "password")
+	ref.client = func() (kubernetes.Interface, error) { return client, nil }
+
+	if err := ref.Create(ctx, "glpat-new", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ref.Read(ctx); err != nil || token != "glpat-new" {
+		t.Errorf("Read() after Create() = %s, %v", token, err)
+	}
+	if err := ref.Create(ctx, "glpat-new", time.Now()); err == nil {
+		t.Errorf("Create() expected an error for an existing secret")
+	}
+}
+
+func TestNewFromURLErrors(t *testing.T) {
+	for _, referenceURL := range []string{"k8s://kind/argocd/repo-creds", "k8s:///argocd/repo-creds/password/x"} {
+		u, _ := url.Parse(referenceURL)
+		if _, err := NewFromURL(context.Background(), u); err == nil {
+			t.Errorf("NewFromURL(%s) expected an error", referenceURL)
+		}
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "repo-creds", Namespace: "argocd", ResourceVersion: "41"},
+		Data:       map[string][]byte{"password": []byte("glpat-old")},
+	})
+	// the API server refuses a patch with a resourceVersion other than the current one
+	client.PrependReactor("patch", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		var patch struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		_ = json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &patch)
+		if version := patch.Metadata.ResourceVersion; version != "" && version != "42" {
+			return true, nil, apierrors.NewConflict(corev1.Resource("secrets"), "repo-creds", errors.New("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+	ref, _ := NewTokenReference(ctx, "", "argocd", "repo-creds", "password")
+	ref.client = func() (kubernetes.Interface, error) { return client, nil }
+
+	_, version, err := ref.ReadWithVersion(ctx)
+	if err != nil || version != "41" {
+		t.Fatalf("ReadWithVersion() = %s, %v", version, err)
+	}
+	if err = ref.UpdateIfVersion(ctx, "glpat-loser", time.Now(), version); !errors.Is(err, secretreference.ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if err = ref.UpdateIfVersion(ctx, "glpat-new", time.Now(), "42"); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := ref.Read(ctx); token != "glpat-new" {
+		t.Errorf("Read() after UpdateIfVersion() = %s", token)
+	}
+}
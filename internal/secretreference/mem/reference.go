@@ -0,0 +1,346 @@
+package mem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// ErrInjected is returned by reads and updates for which a failure was injected.
+var ErrInjected = errors.New("injected failure")
+
+// Update records a call to Update of an in-memory secret.
+type Update struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Secret is an in-memory secret, shared by all references with the same name.
+type Secret struct {
+	mutex       sync.Mutex
+	token       string
+	exists      bool
+	version     int
+	history     []revision
+	expiresAt   time.Time
+	updatedAt   time.Time
+	reads       int
+	updates     []Update
+	failReads   []int
+	failUpdates []int
+}
+
+var (
+	secretsMutex sync.Mutex
+	secrets      = make(map[string]*Secret)
+)
+
+// Lookup returns the in-memory secret with the name, creating it if it does not exist.
+func Lookup(name string) *Secret {
+	secret, _ := lookup(name)
+	return secret
+}
+
+func lookup(name string) (*Secret, bool) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	secret, ok := secrets[name]
+	if !ok {
+		secret = &Secret{}
+		secrets[name] = secret
+	}
+	return secret, !ok
+}
+
+// Reset removes all in-memory secrets.
+func Reset() {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	secrets = make(map[string]*Secret)
+}
+
+// Set sets the token of the secret, without recording an update.
+func (s *Secret) Set(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.setToken(token)
+}
+
+// Updates returns the updates of the secret, in order.
+func (s *Secret) Updates() []Update {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.updates)
+}
+
+// FailReads makes the n-th reads of the secret fail, counting from 1.
+func (s *Secret) FailReads(n ...int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failReads = append(s.failReads, n...)
+}
+
+// FailUpdates makes the n-th updates of the secret fail, counting from 1.
+func (s *Secret) FailUpdates(n ...int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failUpdates = append(s.failUpdates, n...)
+}
+
+// revision is a token set in an in-memory secret.
+type revision struct {
+	Token     string
+	CreatedAt time.Time
+	Pruned    bool
+}
+
+// setToken sets the token as a new version of the secret.
+func (s *Secret) setToken(token string) {
+	s.token = token
+	s.exists = true
+	s.version++
+	s.updatedAt = time.Now()
+	s.history = append(s.history, revision{Token: token, CreatedAt: s.updatedAt})
+}
+
+// TokenReference references an in-memory secret, for dry runs and tests.
+type TokenReference struct {
+	url          *url.URL
+	name         string
+	keepVersions int
+	secret       *Secret
+}
+
+func (t TokenReference) String() string {
+	return t.url.String()
+}
+
+// NewFromURL creates a reference to an in-memory secret. The secret is seeded from the
+// environment variable in ?env= or from the JSON fixture file in ?fixture=, which maps secret
+// names to tokens. With ?fail-read= and ?fail-update= failures are injected in the n-th
+// read or update. The options are only applied by the first reference to the secret.
+func NewFromURL(_ context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	// mem://gitlab-pat?env=GITLAB_TOKEN&fail-update=1
+	if referenceURL.Scheme != "mem" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	name := referenceURL.Host + referenceURL.Path
+	if name == "" {
+		return nil, errors.New("expected an url in the form mem://<name>")
+	}
+
+	q, err := url.ParseQuery(referenceURL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	keepVersions, err := secretreference.ParseKeepVersions(q)
+	if err != nil {
+		return nil, err
+	}
+	secret, created := lookup(name)
+	if !created {
+		return &TokenReference{url: referenceURL, name: name, keepVersions: keepVersions, secret: secret}, nil
+	}
+
+	if variable := q.Get("env"); variable != "" {
+		if token, ok := os.LookupEnv(variable); ok {
+			secret.Set(token)
+		}
+	}
+	if fixture := q.Get("fixture"); fixture != "" {
+		token, err := readFixture(fixture, name)
+		if err != nil {
+			return nil, err
+		}
+		secret.Set(token)
+	}
+	for option, inject := range map[string]func(...int){"fail-read": secret.FailReads, "fail-update": secret.FailUpdates} {
+		for _, value := range strings.Split(q.Get(option), ",") {
+			if value == "" {
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %s", option, value)
+			}
+			inject(n)
+		}
+	}
+	return &TokenReference{url: referenceURL, name: name, keepVersions: keepVersions, secret: secret}, nil
+}
+
+// readFixture reads the token of the secret from the JSON fixture file.
+func readFixture(path, name string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var fixture map[string]string
+	if err = json.Unmarshal(content, &fixture); err != nil {
+		return "", fmt.Errorf("invalid fixture %s, %w", path, err)
+	}
+	token, ok := fixture[name]
+	if !ok {
+		return "", fmt.Errorf("no secret %s in fixture %s", name, path)
+	}
+	return token, nil
+}
+
+// Secret returns the in-memory secret referenced.
+func (t TokenReference) Secret() *Secret {
+	return t.secret
+}
+
+// Read reads the token from the in-memory secret
+func (t TokenReference) Read(_ context.Context) (string, error) {
+	s := t.secret
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return t.read()
+}
+
+// read counts the read and returns the token, while holding the lock on the secret.
+func (t TokenReference) read() (string, error) {
+	s := t.secret
+	s.reads++
+	if slices.Contains(s.failReads, s.reads) {
+		return "", fmt.Errorf("read %d of %s, %w", s.reads, t, ErrInjected)
+	}
+	if !s.exists {
+		return "", fmt.Errorf("secret %s %w", t.name, secretreference.ErrNotFound)
+	}
+	return s.token, nil
+}
+
+// Update records the update and stores the token in the in-memory secret
+func (t TokenReference) Update(_ context.Context, token string, expiresAt time.Time) error {
+	s := t.secret
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return t.update(token, expiresAt)
+}
+
+// ReadWithVersion reads the token and the version of the in-memory secret
+func (t TokenReference) ReadWithVersion(_ context.Context) (string, string, error) {
+	s := t.secret
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token, err := t.read()
+	if err != nil {
+		return "", "", err
+	}
+	return token, strconv.Itoa(s.version), nil
+}
+
+// UpdateIfVersion records the update and stores the token in the in-memory secret, if the
+// secret still has the version.
+func (t TokenReference) UpdateIfVersion(_ context.Context, token string, expiresAt time.Time, version string) error {
+	s := t.secret
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if strconv.Itoa(s.version) != version {
+		return fmt.Errorf("%s has version %d, expected %s, %w", t, s.version, version, secretreference.ErrConflict)
+	}
+	return t.update(token, expiresAt)
+}
+
+// update records the update and stores the token, while holding the lock on the secret.
+func (t TokenReference) update(token string, expiresAt time.Time) error {
+	s := t.secret
+	s.updates = append(s.updates, Update{Token: token, ExpiresAt: expiresAt})
+	if slices.Contains(s.failUpdates, len(s.updates)) {
+		return fmt.Errorf("update %d of %s, %w", len(s.updates), t, ErrInjected)
+	}
+	s.setToken(token)
+	s.expiresAt = expiresAt
+	return nil
+}
+
+// Create stores the token in the in-memory secret, if it does not exist yet. The creation is
+// recorded as an update.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	t.secret.mutex.Lock()
+	exists := t.secret.exists
+	t.secret.mutex.Unlock()
+	if exists {
+		return fmt.Errorf("secret %s already exists", t.name)
+	}
+	return t.Update(ctx, token, expiresAt)
+}
+
+// Metadata returns the expiry of the last successful update of the in-memory secret. The
+// version counts the times the token was set.
+func (t TokenReference) Metadata(_ context.Context) (secretreference.Metadata, error) {
+	s := t.secret
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.exists {
+		return secretreference.Metadata{}, fmt.Errorf("secret %s does not exist", t.name)
+	}
+	return secretreference.Metadata{
+		ExpiresAt: s.expiresAt,
+		UpdatedAt: s.updatedAt,
+		Version:   strconv.Itoa(s.version),
+	}, nil
+}
+
+// Versions returns the tokens set in the in-memory secret, newest first.
+func (t TokenReference) Versions(_ context.Context) ([]secretreference.Version, error) {
+	s := t.secret
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	versions := make([]secretreference.Version, 0, len(s.history))
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Pruned {
+			continue
+		}
+		versions = append(versions, secretreference.Version{
+			ID:        strconv.Itoa(i + 1),
+			CreatedAt: s.history[i].CreatedAt,
+			Current:   i == len(s.history)-1,
+		})
+	}
+	return versions, nil
+}
+
+// ReadVersion reads the token of the version of the in-memory secret
+func (t TokenReference) ReadVersion(_ context.Context, id string) (string, error) {
+	s := t.secret
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 || n > len(s.history) || s.history[n-1].Pruned {
+		return "", fmt.Errorf("no version %s of secret %s", id, t.name)
+	}
+	return s.history[n-1].Token, nil
+}
+
+// KeepVersions returns the number of versions to keep, from the keep-versions query parameter.
+func (t TokenReference) KeepVersions() int {
+	return t.keepVersions
+}
+
+// Prune removes all but the newest keep versions of the in-memory secret.
+func (t TokenReference) Prune(_ context.Context, keep int) error {
+	s := t.secret
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := 0; i < len(s.history)-keep; i++ {
+		s.history[i].Pruned = true
+	}
+	return nil
+}
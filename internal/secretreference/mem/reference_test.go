@@ -0,0 +1,77 @@
+package mem
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newReference(t *testing.T, referenceURL string) *TokenReference {
+	u, err := url.Parse(referenceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.(*TokenReference)
+}
+
+func TestSeedAndRecordUpdates(t *testing.T) {
+	defer Reset()
+	ctx := context.Background()
+	t.Setenv("SEED_TOKEN", "glpat-env")
+	fixture := filepath.Join(t.TempDir(), "fixture.json")
+	_ = os.WriteFile(fixture, []byte(`{"from-fixture": "glpat-fixture"}`), 0o600)
+
+	if token, err := newReference(t, "mem://from-env?env=SEED_TOKEN").Read(ctx); err != nil || token != "glpat-env" {
+		t.Errorf("Read() = %s, %v", token, err)
+	}
+	if token, err := newReference(t, "mem://from-fixture?fixture="+fixture).Read(ctx); err != nil || token != "glpat-fixture" {
+		t.Errorf("Read() = %s, %v", token, err)
+	}
+	if _, err := newReference(t, "mem://missing").Read(ctx); err == nil {
+		t.Errorf("Read() expected an error on a missing secret")
+	}
+
+	ref := newReference(t, "mem://from-env")
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := ref.Read(ctx); token != "glpat-new" {
+		t.Errorf("Read() after Update() = %s", token)
+	}
+	if updates := Lookup("from-env").Updates(); len(updates) != 1 || updates[0] != (Update{"glpat-new", expiresAt}) {
+		t.Errorf("unexpected updates %v", updates)
+	}
+	if metadata, err := ref.Metadata(ctx); err != nil || !metadata.ExpiresAt.Equal(expiresAt) || metadata.Version != "2" {
+		t.Errorf("Metadata() = %+v, %v", metadata, err)
+	}
+}
+
+func TestInjectFailures(t *testing.T) {
+	defer Reset()
+	ctx := context.Background()
+	t.Setenv("SEED_TOKEN", "glpat-old")
+
+	ref := newReference(t, "mem://failing?env=SEED_TOKEN&fail-read=2&fail-update=1,3")
+	for i, expectFailure := range []bool{false, true, false} {
+		if _, err := ref.Read(ctx); errors.Is(err, ErrInjected) != expectFailure {
+			t.Errorf("read %d, unexpected error %v", i+1, err)
+		}
+	}
+	for i, expectFailure := range []bool{true, false, true} {
+		if err := ref.Update(ctx, "glpat-new", time.Now()); errors.Is(err, ErrInjected) != expectFailure {
+			t.Errorf("update %d, unexpected error %v", i+1, err)
+		}
+	}
+	if len(ref.Secret().Updates()) != 3 {
+		t.Errorf("expected all updates to be recorded")
+	}
+}
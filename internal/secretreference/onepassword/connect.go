@@ -0,0 +1,308 @@
+package onepassword
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// ConnectTokenReference references a token in 1Password, through a 1Password Connect server.
+type ConnectTokenReference struct {
+	vaultName  string
+	itemName   string
+	path       fieldPath
+	host       string
+	token      string
+	httpClient *http.Client
+}
+
+// connectItem is a 1Password Connect item. It is kept as a generic document, so that
+// attributes unknown to this tool are written back unchanged.
+type connectItem map[string]any
+
+func (t ConnectTokenReference) String() string {
+	return fmt.Sprintf("op://%s/%s%s", t.vaultName, t.itemName, t.path)
+}
+
+// UseConnect returns true if a 1Password Connect server is configured in the environment.
+func UseConnect() bool {
+	return os.Getenv("OP_CONNECT_HOST") != "" && os.Getenv("OP_CONNECT_TOKEN") != ""
+}
+
+// NewConnectTokenReference create a new 1password token reference, using the 1Password Connect
+// server from OP_CONNECT_HOST with the access token OP_CONNECT_TOKEN.
+func NewConnectTokenReference(_ context.Context, vaultName, itemName string) (*ConnectTokenReference, error) {
+	if !UseConnect() {
+		return nil, errors.New("OP_CONNECT_HOST and OP_CONNECT_TOKEN are required to use 1Password Connect")
+	}
+	return &ConnectTokenReference{
+		vaultName:  vaultName,
+		itemName:   itemName,
+		host:       strings.TrimSuffix(os.Getenv("OP_CONNECT_HOST"), "/"),
+		token:      os.Getenv("OP_CONNECT_TOKEN"),
+		httpClient: http.DefaultClient,
+	}, nil
+}
+
+func (t ConnectTokenReference) do(ctx context.Context, method, path string, body, result any) error {
+	var content bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&content).Encode(body); err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, method, t.host+path, &content)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+t.token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := t.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&apiError)
+		err = fmt.Errorf("%s %s failed with status %d: %s", method, path, response.StatusCode, apiError.Message)
+		switch response.StatusCode {
+		case http.StatusConflict:
+			return fmt.Errorf("%s, %w", err, secretreference.ErrConflict)
+		case http.StatusNotFound:
+			return fmt.Errorf("%s, %w", err, secretreference.ErrNotFound)
+		}
+		return err
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// find returns the id of the object with the title or name, or uses the name as id if no such object exists.
+func (t ConnectTokenReference) find(ctx context.Context, path, attribute, name string) (string, error) {
+	var objects []struct {
+		ID string `json:"id"`
+	}
+	filter := url.Values{"filter": {fmt.Sprintf("%s eq %q", attribute, name)}}
+	if err := t.do(ctx, http.MethodGet, path+"?"+filter.Encode(), nil, &objects); err != nil {
+		return "", err
+	}
+	switch len(objects) {
+	case 0:
+		return name, nil
+	case 1:
+		return objects[0].ID, nil
+	default:
+		return "", fmt.Errorf("multiple objects found with %s %s", attribute, name)
+	}
+}
+
+// itemPath returns the API path to the item.
+func (t ConnectTokenReference) itemPath(ctx context.Context) (string, error) {
+	vaultID, err := t.find(ctx, "/v1/vaults", "name", t.vaultName)
+	if err != nil {
+		return "", err
+	}
+	vaultPath := "/v1/vaults/" + url.PathEscape(vaultID)
+	itemID, err := t.find(ctx, vaultPath+"/items", "title", t.itemName)
+	if err != nil {
+		return "", err
+	}
+	return vaultPath + "/items/" + url.PathEscape(itemID), nil
+}
+
+// readItem reads the item from the vault, and returns the API path to the item.
+func (t ConnectTokenReference) readItem(ctx context.Context) (string, connectItem, error) {
+	path, err := t.itemPath(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	var item connectItem
+	if err = t.do(ctx, http.MethodGet, path, nil, &item); err != nil {
+		return "", nil, err
+	}
+	return path, item, nil
+}
+
+// Read reads the token from the field of the specified item and vault. Without a field, the token
+// is read from the credential of an API_CREDENTIAL, or the password of a LOGIN or PASSWORD item.
+func (t ConnectTokenReference) Read(ctx context.Context) (string, error) {
+	token, _, err := t.ReadWithVersion(ctx)
+	return token, err
+}
+
+// ReadWithVersion reads the token and the version of the item.
+func (t ConnectTokenReference) ReadWithVersion(ctx context.Context) (string, string, error) {
+	_, item, err := t.readItem(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	category, _ := item["category"].(string)
+	name, err := t.path.name(category)
+	if err != nil {
+		return "", "", err
+	}
+	if field := item.find(t.path, name); field != nil {
+		value, _ := field["value"].(string)
+		return value, item.version(), nil
+	}
+	return "", "", fmt.Errorf("no field %s found in item", name)
+}
+
+// Update updates the token and expires field values of the specified item and vault.
+func (t ConnectTokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	return t.UpdateIfVersion(ctx, token, expiresAt, "")
+}
+
+// UpdateIfVersion updates the item like Update, if the item still has the version. The item is
+// written back with the version read, so the Connect server refuses a concurrent change as well.
+func (t ConnectTokenReference) UpdateIfVersion(ctx context.Context, token string, expiresAt time.Time, version string) error {
+	path, item, err := t.readItem(ctx)
+	if err != nil {
+		return err
+	}
+	if version != "" && item.version() != version {
+		return fmt.Errorf("item %s is at version %s instead of %s, %w", t, item.version(), version, secretreference.ErrConflict)
+	}
+	category, _ := item["category"].(string)
+	name, err := t.path.name(category)
+	if err != nil {
+		return err
+	}
+	item.setField(t.path, name, "CONCEALED", token)
+	item.setField(fieldPath{}, "expires", "DATE", expiresValue(category, expiresAt))
+	return t.do(ctx, http.MethodPut, path, item, &connectItem{})
+}
+
+// Create creates an API_CREDENTIAL item in the vault, with the credential and expires fields.
+func (t ConnectTokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	vaultID, err := t.find(ctx, "/v1/vaults", "name", t.vaultName)
+	if err != nil {
+		return err
+	}
+	itemsPath := "/v1/vaults/" + url.PathEscape(vaultID) + "/items"
+
+	var items []struct {
+		ID string `json:"id"`
+	}
+	filter := url.Values{"filter": {fmt.Sprintf("title eq %q", t.itemName)}}
+	if err = t.do(ctx, http.MethodGet, itemsPath+"?"+filter.Encode(), nil, &items); err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		return fmt.Errorf("item %s already exists in vault %s", t.itemName, t.vaultName)
+	}
+
+	item := connectItem{
+		"vault":    map[string]any{"id": vaultID},
+		"title":    t.itemName,
+		"category": "API_CREDENTIAL",
+	}
+	name, _ := t.path.name("API_CREDENTIAL")
+	item.setField(t.path, name, "CONCEALED", token)
+	item.setField(fieldPath{}, "expires", "DATE", expiresValue("API_CREDENTIAL", expiresAt))
+	return t.do(ctx, http.MethodPost, itemsPath, item, &connectItem{})
+}
+
+// Metadata reads the expires field, the modification date and the version of the item.
+func (t ConnectTokenReference) Metadata(ctx context.Context) (secretreference.Metadata, error) {
+	var metadata secretreference.Metadata
+	_, item, err := t.readItem(ctx)
+	if err != nil {
+		return metadata, err
+	}
+	if updatedAt, ok := item["updatedAt"].(string); ok {
+		metadata.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+	}
+	metadata.Version = item.version()
+	if field := item.field("expires"); field != nil {
+		value, _ := field["value"].(string)
+		if metadata.ExpiresAt, err = parseExpires(value); err != nil {
+			return metadata, fmt.Errorf("invalid expires field on %s: %w", t, err)
+		}
+	}
+	return metadata, nil
+}
+
+// version returns the version of the item, or an empty string if the item has no version.
+func (i connectItem) version() string {
+	if version, ok := i["version"].(float64); ok {
+		return fmt.Sprintf("%.0f", version)
+	}
+	return ""
+}
+
+// field returns the field with the label, or nil if there is no such field.
+func (i connectItem) field(label string) map[string]any {
+	return i.find(fieldPath{}, label)
+}
+
+// find returns the field with the name in the section of the path, or nil if there is no such field.
+func (i connectItem) find(path fieldPath, name string) map[string]any {
+	sections := make(map[string]string)
+	for _, s := range i.list("sections") {
+		id, _ := s["id"].(string)
+		sections[id], _ = s["label"].(string)
+	}
+	for _, field := range i.list("fields") {
+		id, _ := field["id"].(string)
+		label, _ := field["label"].(string)
+		section, _ := field["section"].(map[string]any)
+		sectionID, _ := section["id"].(string)
+		if path.matches(name, sectionID, sections[sectionID], id, label) {
+			return field
+		}
+	}
+	return nil
+}
+
+// list returns the objects in the named list attribute of the item.
+func (i connectItem) list(name string) []map[string]any {
+	var result []map[string]any
+	values, _ := i[name].([]any)
+	for _, value := range values {
+		if object, ok := value.(map[string]any); ok {
+			result = append(result, object)
+		}
+	}
+	return result
+}
+
+// setField sets the value of the field with the name in the section of the path, adding the field
+// and the section if they do not exist.
+func (i connectItem) setField(path fieldPath, name, fieldType, value string) {
+	if field := i.find(path, name); field != nil {
+		field["value"] = value
+		return
+	}
+	field := map[string]any{"label": name, "type": fieldType, "value": value}
+	if path.section != "" {
+		sectionID := path.section
+		found := false
+		for _, s := range i.list("sections") {
+			if s["id"] == path.section || strings.EqualFold(fmt.Sprint(s["label"]), path.section) {
+				sectionID, _ = s["id"].(string)
+				found = true
+			}
+		}
+		if !found {
+			sections, _ := i["sections"].([]any)
+			i["sections"] = append(sections, map[string]any{"id": sectionID, "label": path.section})
+		}
+		field["section"] = map[string]any{"id": sectionID}
+	}
+	fields, _ := i["fields"].([]any)
+	i["fields"] = append(fields, field)
+}
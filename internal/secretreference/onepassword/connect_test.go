@@ -0,0 +1,217 @@
+package onepassword
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestConnectReadAndUpdate(t *testing.T) {
+	item := map[string]any{
+		"id":        "item-id",
+		"title":     "gitlab access token",
+		"category":  "API_CREDENTIAL",
+		"version":   float64(3),
+		"updatedAt": "2024-05-01T10:00:00Z",
+		"fields": []any{
+			map[string]any{"id": "credential", "label": "credential", "type": "CONCEALED", "value": "glpat-old"},
+			map[string]any{"id": "username", "label": "username", "type": "STRING", "value": "bot"},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/vaults", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("filter") != `name eq "Private"` {
+			_, _ = w.Write([]byte("[]"))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id": "vault-id", "name": "Private"}]`))
+	})
+	mux.HandleFunc("/v1/vaults/vault-id/items", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("filter") != `title eq "gitlab access token"` {
+			_, _ = w.Write([]byte("[]"))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id": "item-id"}]`))
+	})
+	mux.HandleFunc("/v1/vaults/vault-id/items/item-id", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			item = map[string]any{}
+			_ = json.NewDecoder(r.Body).Decode(&item)
+		}
+		_ = json.NewEncoder(w).Encode(item)
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer connect-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	t.Setenv("OP_CONNECT_HOST", server.URL)
+	t.Setenv("OP_CONNECT_TOKEN", "connect-token")
+
+	ctx := context.Background()
+	u, _ := url.Parse("op://Private/gitlab access token")
+	ref, err := NewFromURL(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ref.(*ConnectTokenReference); !ok {
+		t.Fatalf("expected a 1Password Connect reference, got %T", ref)
+	}
+
+	token, err := ref.Read(ctx)
+	if err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err = ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ = ref.Read(ctx); token != "glpat-new" {
+		t.Errorf("Read() after Update() = %s", token)
+	}
+	if field := connectItem(item).field("expires"); field == nil || field["value"] != "1717200000" {
+		t.Errorf("expected expires field, got %v", field)
+	}
+	if field := connectItem(item).field("username"); field == nil || field["value"] != "bot" {
+		t.Errorf("Update() did not retain other fields, got %v", item["fields"])
+	}
+	if item["version"] != float64(3) {
+		t.Errorf("Update() did not retain the item attributes, got %v", item)
+	}
+
+	metadata, err := ref.(*ConnectTokenReference).Metadata(ctx)
+	if err != nil || !metadata.ExpiresAt.Equal(expiresAt) || metadata.Version != "3" ||
+		metadata.UpdatedAt != time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC) {
+		t.Errorf("Metadata() = %+v, %v", metadata, err)
+	}
+}
+
+func TestConnectCreate(t *testing.T) {
+	var created map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/vaults", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": "vault-id", "name": "Private"}]`))
+	})
+	mux.HandleFunc("/v1/vaults/vault-id/items", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			_ = json.NewEncoder(w).Encode(created)
+			return
+		}
+		if r.URL.Query().Get("filter") == `title eq "existing"` {
+			_, _ = w.Write([]byte(`[{"id": "item-id"}]`))
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("OP_CONNECT_HOST", server.URL)
+	t.Setenv("OP_CONNECT_TOKEN", "connect-token")
+
+	ctx := context.Background()
+	ref, _ := NewConnectTokenReference(ctx, "Private", "existing")
+	if err := ref.Create(ctx, "glpat-new", time.Now()); err == nil {
+		t.Errorf("Create() expected an error for an existing item")
+	}
+
+	ref, _ = NewConnectTokenReference(ctx, "Private", "gitlab access token")
+	if err := ref.Create(ctx, "glpat-new", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	item := connectItem(created)
+	if item["category"] != "API_CREDENTIAL" || item["title"] != "gitlab access token" || item.field("credential")["value"] != "glpat-new" {
+		t.Errorf("unexpected item created, %v", created)
+	}
+}
+
+func TestConnectFieldPaths(t *testing.T) {
+	item := map[string]any{
+		"id":       "item-id",
+		"title":    "gitlab",
+		"category": "LOGIN",
+		"version":  float64(1),
+		"sections": []any{map[string]any{"id": "section-id", "label": "Deploy"}},
+		"fields": []any{
+			map[string]any{"id": "username", "label": "username", "type": "STRING", "purpose": "USERNAME", "value": "bot"},
+			map[string]any{"id": "password", "label": "password", "type": "CONCEALED", "purpose": "PASSWORD", "value": "glpat-login"},
+			map[string]any{"id": "field-id", "label": "token", "type": "CONCEALED", "value": "glpat-deploy", "section": map[string]any{"id": "section-id"}},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/vaults", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": "vault-id", "name": "Private"}]`))
+	})
+	mux.HandleFunc("/v1/vaults/vault-id/items", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": "item-id"}]`))
+	})
+	mux.HandleFunc("/v1/vaults/vault-id/items/item-id", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			item = map[string]any{}
+			_ = json.NewDecoder(r.Body).Decode(&item)
+		}
+		_ = json.NewEncoder(w).Encode(item)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("OP_CONNECT_HOST", server.URL)
+	t.Setenv("OP_CONNECT_TOKEN", "connect-token")
+
+	ctx := context.Background()
+	tests := []struct {
+		url   string
+		token string
+	}{
+		{"op://Private/gitlab", "glpat-login"},
+		{"op://Private/gitlab/password", "glpat-login"},
+		{"op://Private/gitlab/Deploy/token", "glpat-deploy"},
+		{"op://Private/gitlab/section-id/field-id", "glpat-deploy"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		ref, err := NewFromURL(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.(*ConnectTokenReference).String() != tt.url {
+			t.Errorf("String() = %s, expected %s", ref, tt.url)
+		}
+		if token, err := ref.Read(ctx); err != nil || token != tt.token {
+			t.Errorf("Read() of %s = %s, %v", tt.url, token, err)
+		}
+	}
+
+	u, _ := url.Parse("op://Private/gitlab/Deploy/token")
+	ref, _ := NewFromURL(ctx, u)
+	if err := ref.Update(ctx, "glpat-new", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := ref.Read(ctx); token != "glpat-new" {
+		t.Errorf("Read() after Update() = %s", token)
+	}
+	if field := connectItem(item).field("password"); field["value"] != "glpat-login" {
+		t.Errorf("Update() changed the password field, got %v", field)
+	}
+	if field := connectItem(item).field("expires"); field == nil || field["value"] != "2024-06-01" {
+		t.Errorf("expected an expires date field, got %v", field)
+	}
+
+	for _, invalid := range []string{"op://Private", "op://Private/gitlab/a/b/c", "op://Private//token"} {
+		u, _ := url.Parse(invalid)
+		if _, err := NewFromURL(ctx, u); err == nil {
+			t.Errorf("NewFromURL(%s) expected an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package onepassword
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// categoryFields maps the supported item categories to the field holding the token by default.
+var categoryFields = map[string]string{
+	"API_CREDENTIAL": "credential",
+	"LOGIN":          "password",
+	"PASSWORD":       "password",
+}
+
+// fieldPath selects the field holding the token, like the section and field of an `op read` reference.
+// Without a section, a field in any section matches.
+type fieldPath struct {
+	section string
+	field   string
+}
+
+// parseFieldPath parses the segments of an op:// URL path following the vault name, as
+// <item>[/[<section>/]<field>].
+func parseFieldPath(segments []string) (string, fieldPath, error) {
+	switch len(segments) {
+	case 1:
+		return segments[0], fieldPath{}, nil
+	case 2:
+		return segments[0], fieldPath{field: segments[1]}, nil
+	case 3:
+		return segments[0], fieldPath{section: segments[1], field: segments[2]}, nil
+	default:
+		return "", fieldPath{}, fmt.Errorf("expected an url in the form op://<vault>/<item>[/[<section>/]<field>]")
+	}
+}
+
+func (f fieldPath) String() string {
+	switch {
+	case f.section != "":
+		return "/" + f.section + "/" + f.field
+	case f.field != "":
+		return "/" + f.field
+	default:
+		return ""
+	}
+}
+
+// name returns the name of the field holding the token in an item of the category. Without an
+// explicit field, the item must be of a category with a default field for the token.
+func (f fieldPath) name(category string) (string, error) {
+	if f.field != "" {
+		return f.field, nil
+	}
+	name, ok := categoryFields[category]
+	if !ok {
+		return "", fmt.Errorf("item of category %s is not supported, expected API_CREDENTIAL, LOGIN or PASSWORD", category)
+	}
+	return name, nil
+}
+
+// matches returns true if the field with the id and label, in the section with the id and label,
+// is the field with the name.
+func (f fieldPath) matches(name, sectionID, sectionLabel, id, label string) bool {
+	if f.section != "" && f.section != sectionID && !strings.EqualFold(f.section, sectionLabel) {
+		return false
+	}
+	return name == id || strings.EqualFold(name, label)
+}
+
+// expiresValue returns the value of the expires field for an item of the category. The expires field of
+// an API credential holds a unix timestamp, other categories get a date field.
+func expiresValue(category string, expiresAt time.Time) string {
+	if category == "API_CREDENTIAL" {
+		return fmt.Sprintf("%d", expiresAt.Unix())
+	}
+	return expiresAt.Format(time.DateOnly)
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/url"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,31 +19,46 @@ import (
 type TokenReference struct {
 	vaultName string
 	itemName  string
+	path      fieldPath
 	client    *op.Client
 }
 
 func (t TokenReference) String() string {
-	return fmt.Sprintf("op://%s/%s", t.vaultName, t.itemName)
+	return fmt.Sprintf("op://%s/%s%s", t.vaultName, t.itemName, t.path)
 }
 
 func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
-	// op://Private/gitlab access token/note
+	// op://Private/gitlab access token/Section/password
 	if referenceURL.Scheme != "op" {
 		return nil, errors.New("unsupported schema " + referenceURL.Scheme + ":")
 	}
-	paths := strings.Split(referenceURL.Path[1:], "/")
-	var vaultName, itemName string
+	paths := strings.Split(strings.TrimPrefix(referenceURL.Path, "/"), "/")
+	vaultName := referenceURL.Host
+	if vaultName == "" {
+		vaultName, paths = paths[0], paths[1:]
+	}
+	if vaultName == "" || len(paths) == 0 || slices.Contains(paths, "") {
+		return nil, errors.New("expected an url in the form op://<vault>/<item>[/[<section>/]<field>]")
+	}
+	itemName, path, err := parseFieldPath(paths)
+	if err != nil {
+		return nil, err
+	}
 
-	vaultName = referenceURL.Host
-	if vaultName != "" && len(paths) == 1 {
-		itemName = paths[0]
-	} else if vaultName == "" && len(paths) == 2 {
-		vaultName = paths[0]
-		itemName = paths[1]
-	} else {
-		return nil, errors.New("expected an url in the form op://<vault>/<item name or id>")
+	if UseConnect() {
+		ref, err := NewConnectTokenReference(ctx, vaultName, itemName)
+		if err != nil {
+			return nil, err
+		}
+		ref.path = path
+		return ref, nil
+	}
+	ref, err := NewTokenReference(ctx, vaultName, itemName)
+	if err != nil {
+		return nil, err
 	}
-	return NewTokenReference(ctx, vaultName, itemName)
+	ref.path = path
+	return ref, nil
 }
 
 // NewTokenStore create a new 1password token reference.
@@ -53,29 +70,147 @@ func NewTokenReference(_ context.Context, vaultName, itemName string) (*TokenRef
 	return &TokenReference{vaultName: vaultName, itemName: itemName, client: op.NewOpClient()}, nil
 }
 
-// Read reads the token from an API_CREDENTIAL in 1Password from the specified item and vault.
-func (t TokenReference) Read(_ context.Context) (string, error) {
+// vaultItem gets the item from the vault. op reports a missing item only in its error message.
+func (t TokenReference) vaultItem() (*op.Item, error) {
 	item, err := t.client.VaultItem(t.itemName, t.vaultName)
+	if err != nil && strings.Contains(err.Error(), "isn't an item") {
+		return nil, fmt.Errorf("%s, %w", strings.TrimSpace(err.Error()), secretreference.ErrNotFound)
+	}
+	return item, err
+}
+
+// Read reads the token from the field of the specified item and vault. Without a field, the token
+// is read from the credential of an API_CREDENTIAL, or the password of a LOGIN or PASSWORD item.
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	token, _, err := t.ReadWithVersion(ctx)
+	return token, err
+}
+
+// ReadWithVersion reads the token and the version of the item.
+func (t TokenReference) ReadWithVersion(_ context.Context) (string, string, error) {
+	item, err := t.vaultItem()
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	name, err := t.path.name(item.Category)
+	if err != nil {
+		return "", "", err
+	}
+	if field := t.field(item, name); field != nil {
+		return field.Value, strconv.Itoa(item.Version), nil
+	}
+	return "", "", fmt.Errorf("no field %s found in item", name)
+}
+
+// Update updates the token and expires field values of the specified item and vault.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	return t.UpdateIfVersion(ctx, token, expiresAt, "")
+}
+
+// UpdateIfVersion updates the item like Update, if the item still has the version. op has no
+// conditional edit, so the version is checked just before the edit.
+func (t TokenReference) UpdateIfVersion(_ context.Context, token string, expiresAt time.Time, version string) error {
+	item, err := t.vaultItem()
+	if err != nil {
+		return err
+	}
+	if current := strconv.Itoa(item.Version); version != "" && current != version {
+		return fmt.Errorf("item %s is at version %s instead of %s, %w", t, current, version, secretreference.ErrConflict)
+	}
+	name, err := t.path.name(item.Category)
+	if err != nil {
+		return err
+	}
+	if field := t.field(item, name); field != nil {
+		name = field.Label
 	}
+
+	expires := op.Assignment{Name: "expires", Value: expiresValue(item.Category, expiresAt)}
 	if item.Category != "API_CREDENTIAL" {
-		return "", errors.New("item found in vault is not of type API_CREDENTIAL")
+		expires.Name = "expires[date]"
 	}
+	_, err = t.client.EditItemField(t.vaultName, t.itemName,
+		op.Assignment{Name: assignmentName(t.path.section, name), Value: token},
+		expires,
+	)
+	return err
+}
 
-	for _, field := range item.Fields {
-		if field.Label == "credential" {
-			return field.Value, nil
+// field returns the field with the name in the item, or nil if there is no such field.
+func (t TokenReference) field(item *op.Item, name string) *op.Field {
+	for i, field := range item.Fields {
+		// the reference of a field in a section is op://<vault>/<item>/<section>/<field>
+		var section string
+		if parts := strings.Split(strings.TrimPrefix(field.Reference, "op://"), "/"); len(parts) == 4 {
+			section = parts[2]
+		}
+		if t.path.matches(name, section, section, field.ID, field.Label) {
+			return &item.Fields[i]
 		}
 	}
-	return "", errors.New("no credential found in item")
+	return nil
 }
 
-// Update updates the credential and expires field values of the specified item and vault.
-func (t TokenReference) Update(_ context.Context, token string, expiresAt time.Time) error {
-	_, err := op.NewOpClient().EditItemField(t.vaultName, t.itemName,
-		op.Assignment{Name: "credential", Value: token},
-		op.Assignment{Name: "expires", Value: fmt.Sprintf("%d", expiresAt.Unix())},
+// assignmentName returns the name of the field in an op item edit assignment, escaping the
+// characters with a special meaning.
+func assignmentName(section, field string) string {
+	escape := strings.NewReplacer(`\`, `\\`, ".", `\.`, "=", `\=`)
+	if section == "" {
+		return escape.Replace(field)
+	}
+	return escape.Replace(section) + "." + escape.Replace(field)
+}
+
+// Create creates an API Credential item in the vault, with the token in the field of the reference,
+// the credential by default, and the expires field.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	if _, err := t.vaultItem(); err == nil {
+		return fmt.Errorf("item %s already exists in vault %s", t.itemName, t.vaultName)
+	} else if !errors.Is(err, secretreference.ErrNotFound) {
+		return err
+	}
+	name, _ := t.path.name("API_CREDENTIAL")
+	cmd := exec.CommandContext(ctx, "op", "item", "create",
+		"--category", "API Credential",
+		"--vault", t.vaultName,
+		"--title", t.itemName,
+		assignmentName(t.path.section, name)+"="+token,
+		fmt.Sprintf("expires=%d", expiresAt.Unix()),
 	)
-	return err
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("op item create failed, %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Metadata reads the expires field, the modification date and the version of the item.
+func (t TokenReference) Metadata(_ context.Context) (secretreference.Metadata, error) {
+	item, err := t.vaultItem()
+	if err != nil {
+		return secretreference.Metadata{}, err
+	}
+	metadata := secretreference.Metadata{
+		UpdatedAt: item.UpdatedAt,
+		Version:   strconv.Itoa(item.Version),
+	}
+	for _, field := range item.Fields {
+		if field.Label == "expires" {
+			if metadata.ExpiresAt, err = parseExpires(field.Value); err != nil {
+				return metadata, fmt.Errorf("invalid expires field on %s: %w", t, err)
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// parseExpires parses the value of an expires field, which is a unix timestamp or a date. An empty
+// field has no expiry date.
+func parseExpires(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.DateOnly, value)
 }
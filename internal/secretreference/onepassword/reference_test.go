@@ -0,0 +1,71 @@
+package onepassword
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// fakeOp is a stand-in for the op CLI, which knows no items and records the arguments of other
+// commands, one per line.
+const fakeOp = `#!/bin/sh
+if [ "$1 $2" = "item get" ]; then
+	echo "[ERROR] 2024/06/01 00:00:00 \"$3\" isn't an item in the \"$5\" vault. Specify the item with its UUID, name, or domain." >&2
+	exit 1
+fi
+printf '%s\n' "$@" > "$OP_ARGS"
+`
+
+// installOp makes the fake op CLI available, and returns a function reading the arguments it was invoked with.
+func installOp(t *testing.T) func() []string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "op"), []byte(fakeOp), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("OP_ARGS", filepath.Join(dir, "args"))
+	t.Setenv("OP_CONNECT_HOST", "")
+	return func() []string {
+		content, _ := os.ReadFile(filepath.Join(dir, "args"))
+		return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	args := installOp(t)
+	ctx := context.Background()
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		url        string
+		assignment string
+	}{
+		{"op://Private/gitlab", "credential=glpat-new"},
+		{"op://Private/gitlab/token", "token=glpat-new"},
+		{"op://Private/gitlab/Deploy/token", "Deploy.token=glpat-new"},
+		{"op://Private/gitlab/ci.cd/token", `ci\.cd.token=glpat-new`},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		ref, err := NewFromURL(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ref.Read(ctx); !errors.Is(err, secretreference.ErrNotFound) {
+			t.Errorf("Read() of %s expected not found, got %v", tt.url, err)
+		}
+		if err = secretreference.Create(ctx, ref, "glpat-new", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if invoked := args(); len(invoked) != 10 || invoked[8] != tt.assignment || invoked[9] != "expires=1717200000" {
+			t.Errorf("expected op item create with %s, got %v", tt.assignment, invoked)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package pass
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+	"token-manager/internal/secretreference/file"
+)
+
+type TokenReference struct {
+	name     string
+	storeDir string
+}
+
+func (t TokenReference) String() string {
+	return fmt.Sprintf("pass://%s", t.name)
+}
+
+// NewTokenReference create a new reference to an entry in the password store, located in
+// PASSWORD_STORE_DIR or ~/.password-store.
+func NewTokenReference(_ context.Context, name string) (*TokenReference, error) {
+	name = strings.Trim(name, "/")
+	if name == "" || strings.Contains("/"+name+"/", "/../") {
+		return nil, errors.New("expected an url in the form pass://<path in store>")
+	}
+	storeDir := os.Getenv("PASSWORD_STORE_DIR")
+	if storeDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		storeDir = filepath.Join(home, ".password-store")
+	}
+	return &TokenReference{name: name, storeDir: storeDir}, nil
+}
+
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	// pass://gitlab.com/personal-access-token
+	if referenceURL.Scheme != "pass" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	return NewTokenReference(ctx, referenceURL.Host+referenceURL.Path)
+}
+
+func (t TokenReference) path() string {
+	return filepath.Join(t.storeDir, filepath.FromSlash(t.name)+".gpg")
+}
+
+// recipients returns the recipients from the .gpg-id file nearest to the entry, as pass does.
+func (t TokenReference) recipients() ([]string, error) {
+	for dir := filepath.Dir(t.path()); ; dir = filepath.Dir(dir) {
+		content, err := os.ReadFile(filepath.Join(dir, ".gpg-id"))
+		if err == nil {
+			var recipients []string
+			for _, line := range strings.Split(string(content), "\n") {
+				if line, _, _ = strings.Cut(line, "#"); strings.TrimSpace(line) != "" {
+					recipients = append(recipients, strings.TrimSpace(line))
+				}
+			}
+			return recipients, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if rel, _ := filepath.Rel(t.storeDir, dir); rel == "." || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("no .gpg-id found in password store %s", t.storeDir)
+		}
+	}
+}
+
+// gpg runs gpg with the options of pass and returns the standard output.
+func gpg(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	options := append(strings.Fields(os.Getenv("PASSWORD_STORE_GPG_OPTS")), "--quiet", "--yes", "--batch", "--compress-algo=none", "--no-encrypt-to")
+	cmd := exec.CommandContext(ctx, "gpg", append(options, args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg %s failed, %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// decrypt returns the decrypted content of the entry
+func (t TokenReference) decrypt(ctx context.Context) (string, error) {
+	if _, err := os.Stat(t.path()); errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%s %w", t, secretreference.ErrNotFound)
+	}
+	content, err := gpg(ctx, nil, "--decrypt", t.path())
+	return string(content), err
+}
+
+// Read reads the token from the first line of the entry
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	content, err := t.decrypt(ctx)
+	if err != nil {
+		return "", err
+	}
+	token, _, _ := strings.Cut(content, "\n")
+	return strings.TrimSuffix(token, "\r"), nil
+}
+
+// Update replaces the first line of the entry with the token, and the expires: line with the
+// expiry date. Other lines are retained. The entry is encrypted for the recipients in .gpg-id,
+// and committed if the password store is a git repository.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	content, err := t.decrypt(ctx)
+	if err != nil && !errors.Is(err, secretreference.ErrNotFound) {
+		return err
+	}
+
+	recipients, err := t.recipients()
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := gpg(ctx, []byte(updateEntry(content, token, expiresAt)), append(recipientArgs(recipients), "--encrypt")...)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(t.path()), 0o700); err != nil {
+		return err
+	}
+	if err = file.WriteFileAtomically(t.path(), encrypted, 0o600); err != nil {
+		return err
+	}
+	return t.commit(ctx, fmt.Sprintf("Rotate %s using token-manager.", t.name))
+}
+
+// Create creates the entry with the token and the expires: line, if the entry does not exist yet.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	if _, err := os.Stat(t.path()); err == nil {
+		return fmt.Errorf("%s already exists", t)
+	}
+	return t.Update(ctx, token, expiresAt)
+}
+
+// updateEntry replaces the password and expires: line of the entry content.
+func updateEntry(content, token string, expiresAt time.Time) string {
+	lines := []string{token}
+	if _, metadata, found := strings.Cut(content, "\n"); found {
+		for _, line := range strings.Split(strings.TrimSuffix(metadata, "\n"), "\n") {
+			if !strings.HasPrefix(strings.ToLower(line), "expires:") {
+				lines = append(lines, line)
+			}
+		}
+	}
+	lines = append(lines, "expires: "+expiresAt.Format(time.DateOnly))
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Metadata reads the expiry date from the expires: line of the entry. The update time is the
+// modification time of the entry.
+func (t TokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	info, err := os.Stat(t.path())
+	if err != nil {
+		return metadata, err
+	}
+	metadata.UpdatedAt = info.ModTime()
+
+	content, err := t.decrypt(ctx)
+	if err != nil {
+		return metadata, err
+	}
+	for _, line := range strings.Split(content, "\n")[1:] {
+		if len(line) > len("expires:") && strings.EqualFold(line[:len("expires:")], "expires:") {
+			expires := strings.TrimSpace(line[len("expires:"):])
+			if metadata.ExpiresAt, err = time.Parse(time.DateOnly, expires); err != nil {
+				return metadata, fmt.Errorf("invalid expires line in %s: %w", t, err)
+			}
+		}
+	}
+	return metadata, nil
+}
+
+func recipientArgs(recipients []string) []string {
+	args := make([]string, 0, 2*len(recipients))
+	for _, recipient := range recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	return args
+}
+
+// commit commits the entry, if the password store is a git repository.
+func (t TokenReference) commit(ctx context.Context, message string) error {
+	if _, err := os.Stat(filepath.Join(t.storeDir, ".git")); err != nil {
+		return nil
+	}
+	for _, args := range [][]string{{"add", t.path()}, {"commit", "--quiet", "--message", message}} {
+		output, err := exec.CommandContext(ctx, "git", append([]string{"-C", t.storeDir}, args...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git %s failed, %w: %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
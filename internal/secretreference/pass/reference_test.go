@@ -0,0 +1,107 @@
+package pass
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newPasswordStore creates a password store with a new gpg key, in a temporary GNUPGHOME.
+func newPasswordStore(t *testing.T) string {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not found in $PATH")
+	}
+	gnupgHome, err := os.MkdirTemp("", "gnupg-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		_ = os.RemoveAll(gnupgHome)
+	})
+	t.Setenv("GNUPGHOME", gnupgHome)
+
+	output, err := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key",
+		"token-manager@example.com", "default", "default", "never").CombinedOutput()
+	if err != nil {
+		t.Skipf("failed to generate gpg key, %s", output)
+	}
+
+	storeDir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(storeDir, ".gpg-id"), []byte("token-manager@example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PASSWORD_STORE_DIR", storeDir)
+	return storeDir
+}
+
+func TestReadAndUpdate(t *testing.T) {
+	storeDir := newPasswordStore(t)
+	ctx := context.Background()
+
+	u, _ := url.Parse("pass://gitlab.com/personal-access-token")
+	ref, err := NewFromURL(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passRef := ref.(*TokenReference)
+	if passRef.String() != u.String() || passRef.path() != filepath.Join(storeDir, "gitlab.com", "personal-access-token.gpg") {
+		t.Errorf("unexpected reference %s to %s", passRef, passRef.path())
+	}
+
+	if err = ref.Update(ctx, "glpat-old", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, _ := gpg(ctx, []byte("glpat-old\nuser: bot\nexpires: 2024-05-01\n"), "--recipient", "token-manager@example.com", "--encrypt")
+	if err = os.WriteFile(passRef.path(), encrypted, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := ref.Read(ctx)
+	if err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+
+	if err = ref.Update(ctx, "glpat-new", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	content, err := passRef.decrypt(ctx)
+	if err != nil || content != "glpat-new\nuser: bot\nexpires: 2024-06-01\n" {
+		t.Errorf("unexpected entry after Update(), %q, %v", content, err)
+	}
+	if metadata, err := passRef.Metadata(ctx); err != nil || metadata.ExpiresAt.Format(time.DateOnly) != "2024-06-01" {
+		t.Errorf("Metadata() = %+v, %v", metadata, err)
+	}
+}
+
+func TestUpdateEntry(t *testing.T) {
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct{ content, expected string }{
+		{"", "glpat-new\nexpires: 2024-06-01\n"},
+		{"glpat-old", "glpat-new\nexpires: 2024-06-01\n"},
+		{"glpat-old\nurl: https://gitlab.com\nExpires: 2024-05-01\n", "glpat-new\nurl: https://gitlab.com\nexpires: 2024-06-01\n"},
+	}
+	for _, tt := range tests {
+		if got := updateEntry(tt.content, "glpat-new", expiresAt); got != tt.expected {
+			t.Errorf("updateEntry(%q) = %q, expected %q", tt.content, got, tt.expected)
+		}
+	}
+}
+
+func TestRecipientsFromNearestGpgId(t *testing.T) {
+	storeDir := t.TempDir()
+	t.Setenv("PASSWORD_STORE_DIR", storeDir)
+	_ = os.MkdirAll(filepath.Join(storeDir, "team", "gitlab"), 0o700)
+	_ = os.WriteFile(filepath.Join(storeDir, ".gpg-id"), []byte("me@example.com\n"), 0o600)
+	_ = os.WriteFile(filepath.Join(storeDir, "team", ".gpg-id"), []byte("alice@example.com\n# comment\nbob@example.com\n"), 0o600)
+
+	ref, _ := NewTokenReference(context.Background(), "team/gitlab/deploy-token")
+	recipients, err := ref.recipients()
+	if err != nil || len(recipients) != 2 || recipients[0] != "alice@example.com" || recipients[1] != "bob@example.com" {
+		t.Errorf("recipients() = %v, %v", recipients, err)
+	}
+}
@@ -0,0 +1,224 @@
+package secretreference
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatParameter is the query parameter of a reference URL selecting the format of the secret.
+const FormatParameter = "format"
+
+// Payload is the content of a secret in the json format. Besides the token, it holds the details
+// consumers need to use the token, like the user name for a docker login.
+type Payload struct {
+	Token     string   `json:"token"`
+	ID        int      `json:"id,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	User      string   `json:"user,omitempty"`
+	Host      string   `json:"host,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	ExpiresAt string   `json:"expires_at,omitempty"`
+}
+
+// PayloadFields are the names of the fields of the json payload.
+var PayloadFields = []string{"token", "id", "name", "user", "host", "scopes", "expires_at"}
+
+// Field returns the value of the named field of the payload. Scopes are separated by commas.
+func (p Payload) Field(name string) (string, error) {
+	switch name {
+	case "token":
+		return p.Token, nil
+	case "id":
+		if p.ID == 0 {
+			return "", nil
+		}
+		return strconv.Itoa(p.ID), nil
+	case "name":
+		return p.Name, nil
+	case "user":
+		return p.User, nil
+	case "host":
+		return p.Host, nil
+	case "scopes":
+		return strings.Join(p.Scopes, ","), nil
+	case "expires_at":
+		return p.ExpiresAt, nil
+	default:
+		return "", fmt.Errorf("unknown field %s, expected one of %s", name, strings.Join(PayloadFields, ", "))
+	}
+}
+
+// PayloadReference is implemented by references which store the token in a structured payload.
+// SetPayload sets the details of the token written by the next update or create.
+type PayloadReference interface {
+	ReadPayload(ctx context.Context) (Payload, error)
+	SetPayload(payload Payload)
+}
+
+// StoresPayload returns true if the referenced secret holds a structured payload.
+func StoresPayload(ref SecretReference) bool {
+	_, ok := ref.(PayloadReference)
+	return ok
+}
+
+// SetPayload sets the details of the token written by the next update of the referenced secret.
+// It has no effect on a reference to a plain text secret.
+func SetPayload(ref SecretReference, payload Payload) {
+	if writer, ok := ref.(PayloadReference); ok {
+		writer.SetPayload(payload)
+	}
+}
+
+// ReadPayload reads the payload of the referenced secret. For a plain text secret, the payload
+// only holds the token.
+func ReadPayload(ctx context.Context, ref SecretReference) (Payload, error) {
+	if reader, ok := ref.(PayloadReference); ok {
+		return reader.ReadPayload(ctx)
+	}
+	token, err := ref.Read(ctx)
+	return Payload{Token: token}, err
+}
+
+// WithFormat returns the reference for the format in the query of the reference URL. By default,
+// the secret holds the token as plain text. With format json, it holds a json Payload.
+func WithFormat(ref SecretReference, query url.Values) (SecretReference, error) {
+	switch format := query.Get(FormatParameter); format {
+	case "", "text":
+		return ref, nil
+	case "json":
+		return &jsonReference{ref: ref}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %s, expected text or json", format)
+	}
+}
+
+// jsonReference stores the token as a json Payload in the referenced secret. Secrets still holding
+// a plain text token are read as is, so that they can be switched to the json format.
+type jsonReference struct {
+	ref     SecretReference
+	payload Payload
+}
+
+func (j *jsonReference) String() string {
+	return fmt.Sprint(j.ref)
+}
+
+// Unwrap returns the reference to the secret holding the payload.
+func (j *jsonReference) Unwrap() SecretReference {
+	return j.ref
+}
+
+// decode decodes the content of the secret as a json payload, or as a plain text token.
+func (j *jsonReference) decode(content string) (Payload, error) {
+	var payload Payload
+	if !strings.HasPrefix(strings.TrimSpace(content), "{") {
+		return Payload{Token: content}, nil
+	}
+	if err := json.Unmarshal([]byte(content), &payload); err != nil {
+		return payload, fmt.Errorf("invalid json payload in %s, %w", j.ref, err)
+	}
+	return payload, nil
+}
+
+// encode encodes the token with the details set by SetPayload as a json payload.
+func (j *jsonReference) encode(token string, expiresAt time.Time) (string, error) {
+	payload := j.payload
+	payload.Token = token
+	payload.ExpiresAt = expiresAt.Format(time.DateOnly)
+	content, err := json.Marshal(payload)
+	return string(content), err
+}
+
+// ReadPayload reads the payload from the referenced secret.
+func (j *jsonReference) ReadPayload(ctx context.Context) (Payload, error) {
+	content, err := j.ref.Read(ctx)
+	if err != nil {
+		return Payload{}, err
+	}
+	return j.decode(content)
+}
+
+// SetPayload sets the details of the token written by the next update or create.
+func (j *jsonReference) SetPayload(payload Payload) {
+	j.payload = payload
+}
+
+// Read reads the token from the payload of the referenced secret.
+func (j *jsonReference) Read(ctx context.Context) (string, error) {
+	payload, err := j.ReadPayload(ctx)
+	return payload.Token, err
+}
+
+// Update writes the token, the expiry date and the details set by SetPayload to the referenced secret.
+func (j *jsonReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	content, err := j.encode(token, expiresAt)
+	if err != nil {
+		return err
+	}
+	return j.ref.Update(ctx, content, expiresAt)
+}
+
+// The optional operations are delegated to the referenced secret, encoding and decoding the payload.
+// They are only supported if the referenced secret supports them, see Wrapper.
+
+func (j *jsonReference) WriteOnly() bool {
+	return IsWriteOnly(j.ref)
+}
+
+func (j *jsonReference) Metadata(ctx context.Context) (Metadata, error) {
+	return ReadMetadata(ctx, j.ref)
+}
+
+func (j *jsonReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	content, err := j.encode(token, expiresAt)
+	if err != nil {
+		return err
+	}
+	return Create(ctx, j.ref, content, expiresAt)
+}
+
+func (j *jsonReference) Versions(ctx context.Context) ([]Version, error) {
+	return Versions(ctx, j.ref)
+}
+
+func (j *jsonReference) ReadVersion(ctx context.Context, id string) (string, error) {
+	content, err := ReadVersion(ctx, j.ref, id)
+	if err != nil {
+		return "", err
+	}
+	payload, err := j.decode(content)
+	return payload.Token, err
+}
+
+func (j *jsonReference) KeepVersions() int {
+	if pruner, ok := j.ref.(Pruner); ok {
+		return pruner.KeepVersions()
+	}
+	return 0
+}
+
+func (j *jsonReference) Prune(ctx context.Context, keep int) error {
+	return Prune(ctx, j.ref, keep)
+}
+
+func (j *jsonReference) ReadWithVersion(ctx context.Context) (string, string, error) {
+	content, version, err := ReadWithVersion(ctx, j.ref)
+	if err != nil {
+		return "", "", err
+	}
+	payload, err := j.decode(content)
+	return payload.Token, version, err
+}
+
+func (j *jsonReference) UpdateIfVersion(ctx context.Context, token string, expiresAt time.Time, version string) error {
+	content, err := j.encode(token, expiresAt)
+	if err != nil {
+		return err
+	}
+	return UpdateIfVersion(ctx, j.ref, content, expiresAt, version)
+}
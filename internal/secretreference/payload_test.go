@@ -0,0 +1,63 @@
+package secretreference
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// plainReference is a reference to a store which supports none of the optional operations.
+type plainReference struct {
+	token string
+}
+
+func (p *plainReference) Read(ctx context.Context) (string, error) {
+	if p.token == "" {
+		return "", ErrNotFound
+	}
+	return p.token, nil
+}
+
+func (p *plainReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	p.token = token
+	return nil
+}
+
+func TestJSONReferenceOnlySupportsOperationsOfTheStore(t *testing.T) {
+	ctx := context.Background()
+	store := &plainReference{}
+	ref, err := WithFormat(store, url.Values{"format": {"json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if CanCreate(ref) {
+		t.Error("CanCreate() = true, expected false for a store which cannot create secrets")
+	}
+	if err = Create(ctx, ref, "glpat-new", time.Now()); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Create() = %v, expected %v", err, ErrUnsupported)
+	}
+	if err = Prune(ctx, ref, 2); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Prune() = %v, expected %v", err, ErrUnsupported)
+	}
+	if _, err = Versions(ctx, ref); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Versions() = %v, expected %v", err, ErrUnsupported)
+	}
+	if _, err = ReadMetadata(ctx, ref); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("ReadMetadata() = %v, expected %v", err, ErrUnsupported)
+	}
+
+	store.token = "glpat-old"
+	token, version, err := ReadWithVersion(ctx, ref)
+	if err != nil || token != "glpat-old" || version != "" {
+		t.Errorf("ReadWithVersion() = %s, %s, %v, expected the token without a version", token, version, err)
+	}
+	if err = UpdateIfVersion(ctx, ref, "glpat-new", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if store.token != `{"token":"glpat-new","expires_at":"2024-06-01"}` {
+		t.Errorf("expected an unconditional update of the payload, got %s", store.token)
+	}
+}
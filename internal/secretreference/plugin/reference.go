@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// CommandPrefix is the prefix of the plugin command implementing a secret store for a scheme.
+const CommandPrefix = "token-manager-store-"
+
+var schemePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// Request is written as JSON to the standard input of the plugin command. The operation is passed
+// as the first argument of the command: describe, read, update or one of the optional capabilities.
+type Request struct {
+	URL       string     `json:"url"`
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Version   string     `json:"version,omitempty"`
+	Keep      int        `json:"keep,omitempty"`
+}
+
+// Version is a version of the token, as returned by the versions operation.
+type Version struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Current   bool      `json:"current,omitempty"`
+}
+
+// Response is read as JSON from the standard output of the plugin command. A non-empty error
+// indicates the operation failed. On describe, the plugin lists the optional operations it
+// supports in capabilities.
+type Response struct {
+	Token        string     `json:"token,omitempty"`
+	Description  string     `json:"description,omitempty"`
+	WriteOnly    bool       `json:"write_only,omitempty"`
+	Capabilities []string   `json:"capabilities,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
+	Version      string     `json:"version,omitempty"`
+	Versions     []Version  `json:"versions,omitempty"`
+	NotFound     bool       `json:"not_found,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// TokenReference references a token in a secret store implemented by an external command.
+type TokenReference struct {
+	url          *url.URL
+	command      string
+	description  string
+	writeOnly    bool
+	capabilities []string
+}
+
+func (t TokenReference) String() string {
+	return t.url.String()
+}
+
+// WriteOnly returns true if the plugin described the store as write-only.
+func (t TokenReference) WriteOnly() bool {
+	return t.writeOnly
+}
+
+// Command returns the path of the plugin command for the scheme, if it is on the $PATH.
+func Command(scheme string) (string, bool) {
+	if !schemePattern.MatchString(scheme) {
+		return "", false
+	}
+	path, err := exec.LookPath(CommandPrefix + scheme)
+	return path, err == nil
+}
+
+// NewFromURL creates a reference to a token in the store of the plugin for the scheme of the
+// URL. The plugin is asked to describe the reference, so invalid URLs are rejected early.
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	command, ok := Command(referenceURL.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("no %s%s command found in $PATH", CommandPrefix, referenceURL.Scheme)
+	}
+	ref := TokenReference{url: referenceURL, command: command}
+	response, err := ref.call(ctx, "describe", Request{URL: referenceURL.String()})
+	if err != nil {
+		return nil, err
+	}
+	ref.description = response.Description
+	ref.writeOnly = response.WriteOnly
+	ref.capabilities = response.Capabilities
+	return &ref, nil
+}
+
+// supports returns true if the plugin described the operation as one of its capabilities.
+func (t TokenReference) supports(operation string) bool {
+	for _, capability := range t.capabilities {
+		if capability == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// call runs the plugin command for the operation with the request.
+func (t TokenReference) call(ctx context.Context, operation string, request Request) (*Response, error) {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.command, operation)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	var response Response
+	if err = json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("%s %s failed, %w: %s", t.command, operation, runErr, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("%s %s returned an invalid response, %w", t.command, operation, err)
+	}
+	if response.NotFound {
+		return nil, fmt.Errorf("%s %s failed, %s %w", t.command, operation, t, secretreference.ErrNotFound)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s %s failed, %s", t.command, operation, response.Error)
+	}
+	if runErr != nil {
+		return nil, fmt.Errorf("%s %s failed, %w: %s", t.command, operation, runErr, strings.TrimSpace(stderr.String()))
+	}
+	return &response, nil
+}
+
+// Read reads the token through the plugin
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	if t.writeOnly {
+		return "", fmt.Errorf("%s is a %w, the token cannot be read", t, secretreference.ErrWriteOnly)
+	}
+	response, err := t.call(ctx, "read", Request{URL: t.url.String()})
+	if err != nil {
+		return "", err
+	}
+	if response.Token == "" {
+		return "", errors.New("no token returned by " + t.command)
+	}
+	return response.Token, nil
+}
+
+// Update updates the token through the plugin
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	_, err := t.call(ctx, "update", Request{URL: t.url.String(), Token: token, ExpiresAt: &expiresAt})
+	return err
+}
+
+// Create creates the secret holding the token through the plugin, if the plugin has the create capability.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	if !t.supports("create") {
+		return fmt.Errorf("creating %s is %w", t, secretreference.ErrUnsupported)
+	}
+	_, err := t.call(ctx, "create", Request{URL: t.url.String(), Token: token, ExpiresAt: &expiresAt})
+	return err
+}
+
+// Metadata reads the expiry, update time and version of the token through the plugin, if the
+// plugin has the metadata capability.
+func (t TokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	if !t.supports("metadata") {
+		return metadata, fmt.Errorf("reading the metadata of %s is %w", t, secretreference.ErrUnsupported)
+	}
+	response, err := t.call(ctx, "metadata", Request{URL: t.url.String()})
+	if err != nil {
+		return metadata, err
+	}
+	if response.ExpiresAt != nil {
+		metadata.ExpiresAt = *response.ExpiresAt
+	}
+	if response.UpdatedAt != nil {
+		metadata.UpdatedAt = *response.UpdatedAt
+	}
+	metadata.Version = response.Version
+	return metadata, nil
+}
+
+// Versions returns the versions of the token through the plugin, if the plugin has the versions
+// capability. The plugin returns the versions newest first.
+func (t TokenReference) Versions(ctx context.Context) ([]secretreference.Version, error) {
+	if !t.supports("versions") {
+		return nil, fmt.Errorf("listing the versions of %s is %w", t, secretreference.ErrUnsupported)
+	}
+	response, err := t.call(ctx, "versions", Request{URL: t.url.String()})
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]secretreference.Version, 0, len(response.Versions))
+	for _, version := range response.Versions {
+		versions = append(versions, secretreference.Version(version))
+	}
+	return versions, nil
+}
+
+// ReadVersion reads the version of the token through the plugin, using the read-version operation.
+func (t TokenReference) ReadVersion(ctx context.Context, id string) (string, error) {
+	if !t.supports("versions") {
+		return "", fmt.Errorf("reading a version of %s is %w", t, secretreference.ErrUnsupported)
+	}
+	response, err := t.call(ctx, "read-version", Request{URL: t.url.String(), Version: id})
+	if err != nil {
+		return "", err
+	}
+	if response.Token == "" {
+		return "", errors.New("no token returned by " + t.command)
+	}
+	return response.Token, nil
+}
+
+// KeepVersions returns the number of versions to keep, from the keep-versions query parameter.
+func (t TokenReference) KeepVersions() int {
+	keep, _ := secretreference.ParseKeepVersions(t.url.Query())
+	return keep
+}
+
+// Prune removes all but the newest keep versions of the token through the plugin, if the plugin has
+// the prune capability.
+func (t TokenReference) Prune(ctx context.Context, keep int) error {
+	if !t.supports("prune") {
+		return fmt.Errorf("pruning the versions of %s is %w", t, secretreference.ErrUnsupported)
+	}
+	_, err := t.call(ctx, "prune", Request{URL: t.url.String(), Keep: keep})
+	return err
+}
@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// TestMain runs the test binary as a plugin, if invoked as token-manager-store-*. The plugin stores
+// the token in the file named by the path of the URL.
+func TestMain(m *testing.M) {
+	if strings.HasPrefix(filepath.Base(os.Args[0]), CommandPrefix) {
+		os.Exit(runPlugin(os.Args[1]))
+	}
+	os.Exit(m.Run())
+}
+
+func runPlugin(operation string) int {
+	var request Request
+	var response Response
+	_ = json.NewDecoder(os.Stdin).Decode(&request)
+	u, _ := url.Parse(request.URL)
+
+	switch operation {
+	case "describe":
+		if u.Path == "" {
+			response.Error = "expected a path"
+		}
+		response.WriteOnly = u.Host == "write-only"
+		response.Description = "test store"
+		if u.Host != "write-only" {
+			response.Capabilities = []string{"metadata", "create"}
+		}
+	case "read":
+		content, err := os.ReadFile(u.Path)
+		response.Token = string(content)
+		if errors.Is(err, os.ErrNotExist) {
+			response.NotFound = true
+		} else if err != nil {
+			response.Error = err.Error()
+		}
+	case "update":
+		content := request.Token + "\n" + request.ExpiresAt.Format(time.DateOnly)
+		if err := os.WriteFile(u.Path, []byte(content), 0o600); err != nil {
+			response.Error = err.Error()
+		}
+	case "create":
+		file, err := os.OpenFile(u.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			response.Error = err.Error()
+			break
+		}
+		_, _ = file.WriteString(request.Token + "\n" + request.ExpiresAt.Format(time.DateOnly))
+		_ = file.Close()
+	case "metadata":
+		content, err := os.ReadFile(u.Path)
+		if err != nil {
+			response.Error = err.Error()
+			break
+		}
+		_, expires, _ := strings.Cut(string(content), "\n")
+		expiresAt, _ := time.Parse(time.DateOnly, expires)
+		response.ExpiresAt = &expiresAt
+	default:
+		os.Stderr.WriteString("unknown operation " + operation)
+		return 1
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(response)
+	return 0
+}
+
+// installPlugin makes the test binary available as the plugin for the scheme test.
+func installPlugin(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Symlink(executable, filepath.Join(dir, CommandPrefix+"test")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestReadAndUpdate(t *testing.T) {
+	installPlugin(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "token")
+
+	u, _ := url.Parse("test://store" + path)
+	ref, err := NewFromURL(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.(*TokenReference).String() != u.String() || secretreference.IsWriteOnly(ref) {
+		t.Errorf("unexpected reference %s", ref)
+	}
+
+	if _, err = ref.Read(ctx); !errors.Is(err, secretreference.ErrNotFound) {
+		t.Errorf("Read() expected not found for a missing secret, got %v", err)
+	}
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err = secretreference.Create(ctx, ref, "glpat-old", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err = secretreference.Create(ctx, ref, "glpat-old", expiresAt); err == nil {
+		t.Errorf("Create() expected an error for an existing secret")
+	}
+	if err = ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ref.Read(ctx)
+	if err != nil || token != "glpat-new\n2024-06-01" {
+		t.Errorf("Read() = %s, %v", token, err)
+	}
+	metadata, err := secretreference.ReadMetadata(ctx, ref)
+	if err != nil || !metadata.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("ReadMetadata() = %+v, %v", metadata, err)
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	installPlugin(t)
+	ctx := context.Background()
+
+	u, _ := url.Parse("test://store")
+	if _, err := NewFromURL(ctx, u); err == nil || !strings.Contains(err.Error(), "expected a path") {
+		t.Errorf("NewFromURL() expected the error of the plugin, got %v", err)
+	}
+
+	u, _ = url.Parse("test://write-only/token")
+	ref, err := NewFromURL(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ref.Read(ctx); !errors.Is(err, secretreference.ErrWriteOnly) {
+		t.Errorf("Read() expected a write-only error, got %v", err)
+	}
+	if _, err = secretreference.ReadMetadata(ctx, ref); !errors.Is(err, secretreference.ErrUnsupported) {
+		t.Errorf("ReadMetadata() expected an unsupported error, got %v", err)
+	}
+
+	u, _ = url.Parse("missing://store/token")
+	if _, err = NewFromURL(ctx, u); err == nil {
+		t.Errorf("NewFromURL() expected an error for a missing plugin")
+	}
+}
@@ -2,6 +2,10 @@ package secretreference
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -10,3 +14,195 @@ type SecretReference interface {
 	Read(ctx context.Context) (string, error)
 	Update(ctx context.Context, token string, expiresAt time.Time) error
 }
+
+// ErrWriteOnly is returned when reading a token from a store which only allows writing.
+var ErrWriteOnly = errors.New("write-only secret store")
+
+// WriteOnlyReference is implemented by references to stores from which a token cannot be read back.
+type WriteOnlyReference interface {
+	WriteOnly() bool
+}
+
+// IsWriteOnly returns true if the token cannot be read from the referenced store.
+func IsWriteOnly(ref SecretReference) bool {
+	writeOnly, ok := ref.(WriteOnlyReference)
+	return ok && writeOnly.WriteOnly()
+}
+
+// ErrUnsupported is returned when the referenced store does not support an operation.
+var ErrUnsupported = errors.New("not supported by the secret store")
+
+// ErrNotFound is returned when reading a token from a secret which does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Wrapper is implemented by references which wrap the reference to a secret, like the reference to a
+// json payload. The optional operations of a wrapper are only supported if the wrapped reference
+// supports them.
+type Wrapper interface {
+	Unwrap() SecretReference
+}
+
+// supports returns true if the reference, and each reference it wraps, implements the operation T.
+func supports[T any](ref SecretReference) bool {
+	for {
+		if _, ok := ref.(T); !ok {
+			return false
+		}
+		wrapper, ok := ref.(Wrapper)
+		if !ok {
+			return true
+		}
+		ref = wrapper.Unwrap()
+	}
+}
+
+// Metadata describes the token stored in a secret store. Attributes the store does not keep are
+// left at their zero value.
+type Metadata struct {
+	ExpiresAt time.Time
+	UpdatedAt time.Time
+	Version   string
+}
+
+// MetadataReader is implemented by references to stores which keep metadata of the token.
+type MetadataReader interface {
+	Metadata(ctx context.Context) (Metadata, error)
+}
+
+// ReadMetadata reads the metadata of the token from the referenced store.
+func ReadMetadata(ctx context.Context, ref SecretReference) (Metadata, error) {
+	reader, ok := ref.(MetadataReader)
+	if !ok || !supports[MetadataReader](ref) {
+		return Metadata{}, fmt.Errorf("reading the metadata of %s is %w", ref, ErrUnsupported)
+	}
+	return reader.Metadata(ctx)
+}
+
+// Creator is implemented by references to stores in which the secret can be created. Create
+// creates the secret holding the token, and fails if the secret already exists.
+type Creator interface {
+	Create(ctx context.Context, token string, expiresAt time.Time) error
+}
+
+// CanCreate returns true if the referenced secret can be created.
+func CanCreate(ref SecretReference) bool {
+	return supports[Creator](ref)
+}
+
+// Create creates the referenced secret holding the token.
+func Create(ctx context.Context, ref SecretReference, token string, expiresAt time.Time) error {
+	creator, ok := ref.(Creator)
+	if !ok || !CanCreate(ref) {
+		return fmt.Errorf("creating %s is %w", ref, ErrUnsupported)
+	}
+	return creator.Create(ctx, token, expiresAt)
+}
+
+// Version is a version of the token kept by a secret store.
+type Version struct {
+	ID        string
+	CreatedAt time.Time
+	Current   bool
+}
+
+// Versioner is implemented by references to stores which keep previous versions of the token.
+// Versions returns the versions that can still be read, newest first.
+type Versioner interface {
+	Versions(ctx context.Context) ([]Version, error)
+	ReadVersion(ctx context.Context, id string) (string, error)
+}
+
+// Versions returns the versions of the token kept by the referenced store, newest first.
+func Versions(ctx context.Context, ref SecretReference) ([]Version, error) {
+	versioner, ok := ref.(Versioner)
+	if !ok || !supports[Versioner](ref) {
+		return nil, fmt.Errorf("listing the versions of %s is %w", ref, ErrUnsupported)
+	}
+	return versioner.Versions(ctx)
+}
+
+// ReadVersion reads the version of the token from the referenced store.
+func ReadVersion(ctx context.Context, ref SecretReference, id string) (string, error) {
+	versioner, ok := ref.(Versioner)
+	if !ok || !supports[Versioner](ref) {
+		return "", fmt.Errorf("reading a version of %s is %w", ref, ErrUnsupported)
+	}
+	return versioner.ReadVersion(ctx, id)
+}
+
+// KeepVersionsParameter is the query parameter of a reference URL, which configures the number of
+// versions of the token to keep after a rotation.
+const KeepVersionsParameter = "keep-versions"
+
+// ParseKeepVersions returns the number of versions to keep from the query of a reference URL, or 0.
+func ParseKeepVersions(query url.Values) (int, error) {
+	value := query.Get(KeepVersionsParameter)
+	if value == "" {
+		return 0, nil
+	}
+	keep, err := strconv.Atoi(value)
+	if err != nil || keep < 1 {
+		return 0, fmt.Errorf("%s must be a positive number, got %s", KeepVersionsParameter, value)
+	}
+	return keep, nil
+}
+
+// Pruner is implemented by references to stores which keep previous versions of the token, and
+// can remove them. KeepVersions returns the number of versions to keep configured on the
+// reference, or 0.
+type Pruner interface {
+	KeepVersions() int
+	Prune(ctx context.Context, keep int) error
+}
+
+// Prune removes all but the newest keep versions of the token from the referenced store. Without
+// keep, the number of versions configured on the reference is used. Nothing is removed if neither
+// is set.
+func Prune(ctx context.Context, ref SecretReference, keep int) error {
+	pruner, ok := ref.(Pruner)
+	if !ok || !supports[Pruner](ref) {
+		if keep > 0 {
+			return fmt.Errorf("pruning the versions of %s is %w", ref, ErrUnsupported)
+		}
+		return nil
+	}
+	if keep < 1 {
+		keep = pruner.KeepVersions()
+	}
+	if keep < 1 {
+		return nil
+	}
+	return pruner.Prune(ctx, keep)
+}
+
+// ErrConflict is returned by a conditional update, when the secret was changed after it was read.
+var ErrConflict = errors.New("the secret was changed concurrently")
+
+// ConditionalUpdater is implemented by references to stores which support optimistic concurrency.
+// ReadWithVersion returns the token and the version of the secret observed. UpdateIfVersion only
+// updates the token if the secret still has that version, and returns ErrConflict otherwise.
+type ConditionalUpdater interface {
+	ReadWithVersion(ctx context.Context) (token string, version string, err error)
+	UpdateIfVersion(ctx context.Context, token string, expiresAt time.Time, version string) error
+}
+
+// ReadWithVersion reads the token and the version of the secret observed. If the referenced store does
+// not support optimistic concurrency, the version is empty.
+func ReadWithVersion(ctx context.Context, ref SecretReference) (string, string, error) {
+	updater, ok := ref.(ConditionalUpdater)
+	if !ok || !supports[ConditionalUpdater](ref) {
+		token, err := ref.Read(ctx)
+		return token, "", err
+	}
+	return updater.ReadWithVersion(ctx)
+}
+
+// UpdateIfVersion updates the token, if the secret still has the version observed by ReadWithVersion.
+// Without a version, the token is updated unconditionally.
+func UpdateIfVersion(ctx context.Context, ref SecretReference, token string, expiresAt time.Time, version string) error {
+	updater, ok := ref.(ConditionalUpdater)
+	if !ok || version == "" || !supports[ConditionalUpdater](ref) {
+		return ref.Update(ctx, token, expiresAt)
+	}
+	return updater.UpdateIfVersion(ctx, token, expiresAt, version)
+}
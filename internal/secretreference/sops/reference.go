@@ -0,0 +1,148 @@
+package sops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+type TokenReference struct {
+	path string
+	key  string
+}
+
+func (t TokenReference) String() string {
+	return fmt.Sprintf("sops://%s#%s", t.path, t.key)
+}
+
+// NewTokenReference create a new reference to a value in a SOPS encrypted file. The key is
+// a path in the document, like /gitlab/token.
+func NewTokenReference(_ context.Context, path, key string) (*TokenReference, error) {
+	if path == "" || !strings.HasPrefix(key, "/") || len(key) < 2 {
+		return nil, errors.New("expected an url in the form sops://<path to file>#/<path to key>")
+	}
+	return &TokenReference{path: path, key: key}, nil
+}
+
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	// sops://config/production.yaml#/gitlab/token
+	// sops:///etc/token-manager/secrets.yaml#/gitlab/token
+	if referenceURL.Scheme != "sops" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	return NewTokenReference(ctx, referenceURL.Host+referenceURL.Path, referenceURL.Fragment)
+}
+
+// index converts the key to a sops tree index, like ["gitlab"]["token"].
+func (t TokenReference) index() string {
+	var result strings.Builder
+	for _, name := range strings.Split(t.key[1:], "/") {
+		if _, err := strconv.Atoi(name); err == nil {
+			fmt.Fprintf(&result, "[%s]", name)
+		} else {
+			encoded, _ := json.Marshal(name)
+			fmt.Fprintf(&result, "[%s]", encoded)
+		}
+	}
+	return result.String()
+}
+
+// sops runs the sops command with the arguments and returns the standard output.
+func (t TokenReference) sops(ctx context.Context, args ...string) (string, error) {
+	return t.sopsWithInput(ctx, nil, args...)
+}
+
+// sopsWithInput runs the sops command with the arguments and input, and returns the standard output.
+func (t TokenReference) sopsWithInput(ctx context.Context, input []byte, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sops", args...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sops %s failed, %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Read decrypts the value of the key from the file.
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	if _, err := os.Stat(t.path); errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%s %w", t.path, secretreference.ErrNotFound)
+	}
+	return t.sops(ctx, "--decrypt", "--extract", t.index(), t.path)
+}
+
+// Update sets the value of the key in the file. The value is encrypted for the recipients of the
+// file, and the rest of the document remains unchanged.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	_, err = t.sops(ctx, "set", t.path, t.index(), string(value))
+	return err
+}
+
+// Create creates the encrypted file holding only the token in the key. The file is encrypted for
+// the recipients of the matching creation rule in .sops.yaml.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	if _, err := os.Stat(t.path); err == nil {
+		return fmt.Errorf("%s already exists", t.path)
+	}
+
+	var document any = token
+	names := strings.Split(t.key[1:], "/")
+	for i := len(names) - 1; i >= 0; i-- {
+		document = map[string]any{names[i]: document}
+	}
+	plaintext, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	outputType := "yaml"
+	if strings.HasSuffix(t.path, ".json") {
+		outputType = "json"
+	}
+	encrypted, err := t.sopsWithInput(ctx, plaintext, "--encrypt",
+		"--input-type", "json", "--output-type", outputType,
+		"--filename-override", t.path, "/dev/stdin")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(t.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.WriteString(encrypted); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Metadata returns the modification time of the encrypted file. The expiry date of the token
+// is not stored, as that would change the rest of the document.
+func (t TokenReference) Metadata(_ context.Context) (metadata secretreference.Metadata, err error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.UpdatedAt = info.ModTime()
+	return metadata, nil
+}
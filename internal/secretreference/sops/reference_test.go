@@ -0,0 +1,231 @@
+package sops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// TestMain runs the test binary as a fake sops command, if invoked as sops. The fake keeps the
+// documents as plain json, and records its arguments in the file named by SOPS_ARGS.
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == "sops" {
+		os.Exit(runSops(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// indexPattern matches the components of a sops tree index, like ["gitlab"][0].
+var indexPattern = regexp.MustCompile(`\[("(?:[^"\\]|\\.)*"|\d+)\]`)
+
+func runSops(args []string) int {
+	recorded, _ := json.Marshal(args)
+	_ = os.WriteFile(os.Getenv("SOPS_ARGS"), recorded, 0o600)
+
+	switch {
+	case len(args) == 4 && args[0] == "--decrypt" && args[1] == "--extract":
+		var document any
+		content, err := os.ReadFile(args[3])
+		if err == nil {
+			err = json.Unmarshal(content, &document)
+		}
+		if err != nil {
+			os.Stderr.WriteString(err.Error())
+			return 2
+		}
+		for _, component := range indexPattern.FindAllStringSubmatch(args[2], -1) {
+			var ok bool
+			if index, err := strconv.Atoi(component[1]); err == nil {
+				list, _ := document.([]any)
+				if ok = index < len(list); ok {
+					document = list[index]
+				}
+			} else {
+				var name string
+				_ = json.Unmarshal([]byte(component[1]), &name)
+				document, ok = document.(map[string]any)[name]
+			}
+			if !ok {
+				os.Stderr.WriteString("Error walking tree: component " + component[0] + " not found")
+				return 1
+			}
+		}
+		os.Stdout.WriteString(document.(string))
+	case len(args) == 4 && args[0] == "set":
+		var document map[string]any
+		content, _ := os.ReadFile(args[1])
+		_ = json.Unmarshal(content, &document)
+		var value any
+		if err := json.Unmarshal([]byte(args[3]), &value); err != nil {
+			os.Stderr.WriteString("Error: invalid value " + args[3])
+			return 1
+		}
+		components := indexPattern.FindAllStringSubmatch(args[2], -1)
+		branch := document
+		for i, component := range components {
+			var name string
+			_ = json.Unmarshal([]byte(component[1]), &name)
+			if i == len(components)-1 {
+				branch[name] = value
+			} else {
+				branch = branch[name].(map[string]any)
+			}
+		}
+		content, _ = json.Marshal(document)
+		_ = os.WriteFile(args[1], content, 0o600)
+	case len(args) == 8 && args[0] == "--encrypt":
+		var document map[string]any
+		if err := json.NewDecoder(os.Stdin).Decode(&document); err != nil {
+			os.Stderr.WriteString(err.Error())
+			return 1
+		}
+		document["sops"] = map[string]any{"output_type": args[4]}
+		_ = json.NewEncoder(os.Stdout).Encode(document)
+	default:
+		os.Stderr.WriteString("unexpected arguments " + strings.Join(args, " "))
+		return 1
+	}
+	return 0
+}
+
+// installSops makes the test binary available as the sops command, and returns a function reading
+// the arguments of its last invocation.
+func installSops(t *testing.T) func() []string {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Symlink(executable, filepath.Join(dir, "sops")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SOPS_ARGS", filepath.Join(dir, "args"))
+	return func() []string {
+		var args []string
+		content, _ := os.ReadFile(filepath.Join(dir, "args"))
+		_ = json.Unmarshal(content, &args)
+		return args
+	}
+}
+
+// newReference returns the reference to the key in the file in a temporary directory.
+func newReference(t *testing.T, name, key string) *TokenReference {
+	ref, err := NewTokenReference(context.Background(), filepath.Join(t.TempDir(), name), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestNewFromURL(t *testing.T) {
+	tests := []struct {
+		referenceURL string
+		path         string
+		index        string
+	}{
+		{"sops://config/production.yaml#/gitlab/token", "config/production.yaml", `["gitlab"]["token"]`},
+		{"sops:///etc/secrets.json#/tokens/0/value", "/etc/secrets.json", `["tokens"][0]["value"]`},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.referenceURL)
+		ref, err := NewFromURL(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sopsRef := ref.(*TokenReference)
+		if sopsRef.path != tt.path || sopsRef.index() != tt.index || sopsRef.String() != tt.referenceURL {
+			t.Errorf("NewFromURL(%s) = %s, %s, %s", tt.referenceURL, sopsRef.path, sopsRef.index(), sopsRef)
+		}
+	}
+
+	u, _ := url.Parse("sops://config/production.yaml")
+	if _, err := NewFromURL(context.Background(), u); err == nil {
+		t.Errorf("expected an error for a reference without a key")
+	}
+}
+
+func TestReadAndUpdate(t *testing.T) {
+	args := installSops(t)
+	ctx := context.Background()
+	ref := newReference(t, "secrets.yaml", `/gitlab/deploy "bot"/token`)
+	content := `{"gitlab": {"deploy \"bot\"": {"token": "glpat-old"}, "url": "https://gitlab.com"}}`
+	if err := os.WriteFile(ref.path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err := ref.Read(ctx); err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+	expected := []string{"--decrypt", "--extract", `["gitlab"]["deploy \"bot\""]["token"]`, ref.path}
+	if invoked := args(); strings.Join(invoked, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected sops %v, got %v", expected, invoked)
+	}
+
+	if err := ref.Update(ctx, `glpat-"new"`, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"set", ref.path, `["gitlab"]["deploy \"bot\""]["token"]`, `"glpat-\"new\""`}
+	if invoked := args(); strings.Join(invoked, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected sops %v, got %v", expected, invoked)
+	}
+	if token, err := ref.Read(ctx); err != nil || token != `glpat-"new"` {
+		t.Errorf("Read() after Update() = %s, %v", token, err)
+	}
+}
+
+func TestReadNotFound(t *testing.T) {
+	installSops(t)
+	ctx := context.Background()
+
+	ref := newReference(t, "missing.yaml", "/gitlab/token")
+	if _, err := ref.Read(ctx); !errors.Is(err, secretreference.ErrNotFound) {
+		t.Errorf("Read() expected not found for a missing file, got %v", err)
+	}
+
+	// a missing key in an existing file cannot be created, so it is not reported as not found
+	ref = newReference(t, "secrets.yaml", "/gitlab/token")
+	if err := os.WriteFile(ref.path, []byte(`{"gitlab": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ref.Read(ctx)
+	if err == nil || errors.Is(err, secretreference.ErrNotFound) || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Read() expected the sops error for a missing key, got %v", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	args := installSops(t)
+	ctx := context.Background()
+
+	for _, tt := range []struct{ name, outputType string }{{"secrets.yaml", "yaml"}, {"secrets.json", "json"}} {
+		ref := newReference(t, tt.name, "/gitlab/token")
+		if err := ref.Create(ctx, "glpat-new", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"--encrypt", "--input-type", "json", "--output-type", tt.outputType,
+			"--filename-override", ref.path, "/dev/stdin"}
+		if invoked := args(); strings.Join(invoked, " ") != strings.Join(expected, " ") {
+			t.Errorf("expected sops %v, got %v", expected, invoked)
+		}
+		if info, err := os.Stat(ref.path); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("expected the encrypted file with mode 0600, got %v, %v", info, err)
+		}
+		if token, err := ref.Read(ctx); err != nil || token != "glpat-new" {
+			t.Errorf("Read() after Create() = %s, %v", token, err)
+		}
+		if err := ref.Create(ctx, "glpat-other", time.Now()); err == nil {
+			t.Error("expected an error when the file already exists")
+		}
+	}
+}
@@ -2,9 +2,13 @@ package ssm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,15 +16,37 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
+// ExpiresAtTag is the tag on the parameter holding the expiry date of the token.
+const ExpiresAtTag = "token-manager:expires-at"
+
 type TokenReference struct {
 	parameterName string
 	awsRegion     string
+	options       Options
+	keepVersions  int
 	client        *awsssm.Client
 }
 
+// Options are the options of an AWS SSM parameter reference, set through the query of the reference URL.
+type Options struct {
+	// Region of the parameter, which defaults to the region of the ARN or the AWS configuration.
+	Region string
+	// Profile in the shared AWS configuration to use.
+	Profile string
+	// RoleArn of the role to assume to access the parameter, for parameters in another account.
+	RoleArn string
+	// KmsKeyId of the key to encrypt the parameter with, instead of the default key of the account.
+	KmsKeyId string
+	// Tier of the parameter: Standard, Advanced or Intelligent-Tiering.
+	Tier types.ParameterTier
+}
+
 func (t TokenReference) String() string {
 	if strings.HasPrefix(t.parameterName, "arn:") {
 		return t.parameterName
@@ -30,64 +56,324 @@ func (t TokenReference) String() string {
 
 // NewTokenReference create a new AWS SSM parameter token reference.
 func NewTokenReference(ctx context.Context, parameterName string, awsRegion string) (*TokenReference, error) {
+	return NewTokenReferenceWithOptions(ctx, parameterName, Options{Region: awsRegion})
+}
+
+// NewTokenReferenceWithOptions create a new AWS SSM parameter token reference, accessed with the options.
+func NewTokenReferenceWithOptions(ctx context.Context, parameterName string, options Options) (*TokenReference, error) {
 	if !strings.HasPrefix(parameterName, "arn:") && !strings.HasPrefix(parameterName, "/") {
 		parameterName = "/" + parameterName
 	}
 
 	ref := TokenReference{
-		parameterName: parameterName, awsRegion: awsRegion,
+		parameterName: parameterName, awsRegion: options.Region, options: options,
+	}
+
+	var loadOptions []func(*config.LoadOptions) error
+	if options.Region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(options.Region))
 	}
-	cfg, err := config.LoadDefaultConfig(ctx)
+	if options.Profile != "" {
+		loadOptions = append(loadOptions, config.WithSharedConfigProfile(options.Profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
 	if err != nil {
 		return nil, err
 	}
-	ref.client = awsssm.NewFromConfig(cfg, func(o *awsssm.Options) {
-		if awsRegion != "" {
-			o.Region = awsRegion
-		}
-	})
+	if options.RoleArn != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), options.RoleArn,
+			func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = "token-manager"
+			}))
+	}
+	ref.client = awsssm.NewFromConfig(cfg)
 	return &ref, nil
 }
 
 var parameterPattern = regexp.MustCompile(`^(?P<Partition>[^:]*):ssm:(?P<Region>[^:]*):(?P<AccountID>[^:]*):parameter/(?P<Resource>.*)$`)
 
 func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
-	if referenceURL.Scheme == "arn" {
-		if !parameterPattern.MatchString(referenceURL.Opaque) {
+	options, err := parseOptions(referenceURL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	var name string
+	switch referenceURL.Scheme {
+	case "arn":
+		match := parameterPattern.FindStringSubmatch(referenceURL.Opaque)
+		if match == nil {
 			return nil, fmt.Errorf("unsupported ARN %s", referenceURL.Scheme)
 		}
-
-		return NewTokenReference(ctx, "arn:"+referenceURL.Opaque, "")
+		name = "arn:" + referenceURL.Opaque
+		region := match[parameterPattern.SubexpIndex("Region")]
+		if options.Region != "" && region != "" && options.Region != region {
+			return nil, fmt.Errorf("region %s does not match the region of the ARN %s", options.Region, name)
+		}
+		if region != "" {
+			options.Region = region
+		}
+	case "ssm":
+		name = referenceURL.Path
+	default:
+		return nil, fmt.Errorf("unsupported URL %s", referenceURL)
 	}
 
-	if referenceURL.Scheme == "ssm" {
-		return NewTokenReference(ctx, referenceURL.Path, "")
+	keepVersions, err := secretreference.ParseKeepVersions(referenceURL.Query())
+	if err != nil {
+		return nil, err
 	}
+	ref, err := NewTokenReferenceWithOptions(ctx, name, options)
+	if err != nil {
+		return nil, err
+	}
+	ref.keepVersions = keepVersions
+	return ref, nil
+}
 
-	return nil, fmt.Errorf("unsupported URL %s", referenceURL)
+// parseOptions parses the options from the query of the reference URL.
+func parseOptions(query url.Values) (Options, error) {
+	options := Options{
+		Region:   query.Get("region"),
+		Profile:  query.Get("profile"),
+		RoleArn:  query.Get("role-arn"),
+		KmsKeyId: query.Get("kms-key-id"),
+		Tier:     types.ParameterTier(query.Get("tier")),
+	}
+	if options.Tier != "" && !slices.Contains(options.Tier.Values(), options.Tier) {
+		return options, fmt.Errorf("unsupported tier %s, expected one of %v", options.Tier, options.Tier.Values())
+	}
+	return options, nil
 }
 
 // ReadToken reads the token from the SSM parameter
 func (t TokenReference) Read(ctx context.Context) (string, error) {
+	token, _, err := t.ReadWithVersion(ctx)
+	return token, err
+}
+
+// ReadWithVersion reads the token and the version of the SSM parameter.
+func (t TokenReference) ReadWithVersion(ctx context.Context) (string, string, error) {
 	response, err := t.client.GetParameter(ctx,
 		&awsssm.GetParameterInput{
 			Name:           aws.String(t.parameterName),
 			WithDecryption: aws.Bool(true),
 		})
-	if err != nil {
-		return "", err
+	var notFound *types.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return "", "", fmt.Errorf("%s, %w", err, secretreference.ErrNotFound)
+	} else if err != nil {
+		return "", "", err
 	}
-	return *response.Parameter.Value, nil
+	return *response.Parameter.Value, strconv.FormatInt(response.Parameter.Version, 10), nil
 }
 
-// UpdateToken updates the SSM parameter with the token
+// UpdateToken updates the SSM parameter with the token, and tags it with the expiry date. Tagging is
+// best effort, a failure is logged.
 func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
-	_, err := t.client.PutParameter(ctx,
-		&awsssm.PutParameterInput{
-			Name:      aws.String(t.parameterName),
-			Value:     aws.String(token),
-			Overwrite: aws.Bool(true),
+	return t.UpdateIfVersion(ctx, token, expiresAt, "")
+}
+
+// UpdateIfVersion updates the SSM parameter like Update, if the parameter still has the version.
+// Parameter Store has no conditional write, so the version is checked before and after the
+// write. When another writer slipped in between, its value is restored on top of ours.
+func (t TokenReference) UpdateIfVersion(ctx context.Context, token string, expiresAt time.Time, version string) error {
+	var observed int64
+	if version != "" {
+		var err error
+		if observed, err = strconv.ParseInt(version, 10, 64); err != nil {
+			return fmt.Errorf("invalid version %s for parameter %s", version, t)
+		}
+		_, current, err := t.ReadWithVersion(ctx)
+		if err != nil {
+			return err
+		}
+		if current != version {
+			return fmt.Errorf("parameter %s is at version %s instead of %s, %w", t, current, version, secretreference.ErrConflict)
+		}
+	}
+
+	response, err := t.putParameter(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if version != "" && response.Version != observed+1 {
+		winner, err := t.ReadVersion(ctx, strconv.FormatInt(observed+1, 10))
+		if err != nil {
+			return fmt.Errorf("parameter %s was changed concurrently, failed to restore version %d, %w", t, observed+1, err)
+		}
+		if _, err = t.putParameter(ctx, winner); err != nil {
+			return fmt.Errorf("parameter %s was changed concurrently, failed to restore version %d, %w", t, observed+1, err)
+		}
+		return fmt.Errorf("parameter %s was changed concurrently, %w", t, secretreference.ErrConflict)
+	}
+
+	// the token is stored, so a missing permission to tag the parameter must not fail the update
+	_, err = t.client.AddTagsToResource(ctx,
+		&awsssm.AddTagsToResourceInput{
+			ResourceType: types.ResourceTypeForTaggingParameter,
+			ResourceId:   aws.String(t.resourceId()),
+			Tags:         []types.Tag{{Key: aws.String(ExpiresAtTag), Value: aws.String(expiresAt.Format(time.RFC3339))}},
 		})
+	if err != nil {
+		log.Printf("failed to tag %s with the expiry date, %s", t, err)
+	}
+	return nil
+}
 
+// putParameter overwrites the value of the parameter. With a KMS key, the parameter is stored as a
+// SecureString encrypted with that key.
+func (t TokenReference) putParameter(ctx context.Context, value string) (*awsssm.PutParameterOutput, error) {
+	input := &awsssm.PutParameterInput{
+		Name:      aws.String(t.parameterName),
+		Value:     aws.String(value),
+		Overwrite: aws.Bool(true),
+		Tier:      t.options.Tier,
+	}
+	if t.options.KmsKeyId != "" {
+		input.Type = types.ParameterTypeSecureString
+		input.KeyId = aws.String(t.options.KmsKeyId)
+	}
+	return t.client.PutParameter(ctx, input)
+}
+
+// Create creates the parameter as a SecureString holding the token, tagged with the expiry date. The
+// parameter is encrypted with the KMS key and stored in the tier of the options.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	input := &awsssm.PutParameterInput{
+		Name:      aws.String(t.resourceId()),
+		Value:     aws.String(token),
+		Type:      types.ParameterTypeSecureString,
+		Overwrite: aws.Bool(false),
+		Tier:      t.options.Tier,
+		Tags:      []types.Tag{{Key: aws.String(ExpiresAtTag), Value: aws.String(expiresAt.Format(time.RFC3339))}},
+	}
+	if t.options.KmsKeyId != "" {
+		input.KeyId = aws.String(t.options.KmsKeyId)
+	}
+	_, err := t.client.PutParameter(ctx, input)
 	return err
 }
+
+// Metadata reads the version and modification date of the parameter, and the expiry date from its tags.
+func (t TokenReference) Metadata(ctx context.Context) (secretreference.Metadata, error) {
+	var metadata secretreference.Metadata
+	response, err := t.client.GetParameter(ctx,
+		&awsssm.GetParameterInput{
+			Name: aws.String(t.parameterName),
+		})
+	if err != nil {
+		return metadata, err
+	}
+	metadata.Version = fmt.Sprintf("%d", response.Parameter.Version)
+	metadata.UpdatedAt = aws.ToTime(response.Parameter.LastModifiedDate)
+
+	tags, err := t.client.ListTagsForResource(ctx,
+		&awsssm.ListTagsForResourceInput{
+			ResourceType: types.ResourceTypeForTaggingParameter,
+			ResourceId:   aws.String(t.resourceId()),
+		})
+	if err != nil {
+		return metadata, err
+	}
+	for _, tag := range tags.TagList {
+		if aws.ToString(tag.Key) == ExpiresAtTag {
+			if metadata.ExpiresAt, err = time.Parse(time.RFC3339, aws.ToString(tag.Value)); err != nil {
+				return metadata, fmt.Errorf("invalid %s tag on %s: %w", ExpiresAtTag, t, err)
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// Versions returns the versions in the history of the parameter, newest first.
+func (t TokenReference) Versions(ctx context.Context) ([]secretreference.Version, error) {
+	var versions []secretreference.Version
+	paginator := awsssm.NewGetParameterHistoryPaginator(t.client,
+		&awsssm.GetParameterHistoryInput{
+			Name: aws.String(t.parameterName),
+		})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, parameter := range page.Parameters {
+			versions = append(versions, secretreference.Version{
+				ID:        fmt.Sprintf("%d", parameter.Version),
+				CreatedAt: aws.ToTime(parameter.LastModifiedDate),
+			})
+		}
+	}
+	slices.Reverse(versions)
+	if len(versions) > 0 {
+		versions[0].Current = true
+	}
+	return versions, nil
+}
+
+// ReadVersion reads the token from the version of the parameter
+func (t TokenReference) ReadVersion(ctx context.Context, id string) (string, error) {
+	response, err := t.client.GetParameter(ctx,
+		&awsssm.GetParameterInput{
+			Name:           aws.String(t.parameterName + ":" + id),
+			WithDecryption: aws.Bool(true),
+		})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(response.Parameter.Value), nil
+}
+
+// KeepVersions returns the number of versions to keep, from the keep-versions query parameter.
+func (t TokenReference) KeepVersions() int {
+	return t.keepVersions
+}
+
+// Prune removes the labels from the versions of the parameter, except the newest keep versions.
+// Parameter Store cannot delete a single version. It removes the oldest version when a parameter
+// reaches 100 versions, but refuses to do so if that version is labelled.
+func (t TokenReference) Prune(ctx context.Context, keep int) error {
+	var history []types.ParameterHistory
+	paginator := awsssm.NewGetParameterHistoryPaginator(t.client,
+		&awsssm.GetParameterHistoryInput{
+			Name: aws.String(t.parameterName),
+		})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		history = append(history, page.Parameters...)
+	}
+
+	for i := 0; i < len(history)-keep; i++ {
+		if len(history[i].Labels) == 0 {
+			continue
+		}
+		_, err := t.client.UnlabelParameterVersion(ctx,
+			&awsssm.UnlabelParameterVersionInput{
+				Name:             aws.String(t.parameterName),
+				ParameterVersion: aws.Int64(history[i].Version),
+				Labels:           history[i].Labels,
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resourceId returns the name of the parameter, as required by the tagging API.
+func (t TokenReference) resourceId() string {
+	match := parameterPattern.FindStringSubmatch(strings.TrimPrefix(t.parameterName, "arn:"))
+	if match == nil {
+		return t.parameterName
+	}
+	name := match[parameterPattern.SubexpIndex("Resource")]
+	if strings.Contains(name, "/") {
+		return "/" + name
+	}
+	return name
+}
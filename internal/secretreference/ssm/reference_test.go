@@ -0,0 +1,270 @@
+package ssm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awsssm "github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"token-manager/internal/secretreference"
+)
+
+func TestNewFromURL(t *testing.T) {
+	tests := []struct {
+		url         string
+		options     Options
+		expectError bool
+	}{
+		{url: "ssm:///gitlab/pat"},
+		{url: "arn:aws:ssm:eu-central-1:123456789012:parameter/gitlab/pat", options: Options{Region: "eu-central-1"}},
+		{
+			url:     "arn:aws:ssm:eu-central-1:123456789012:parameter/gitlab/pat?region=eu-central-1&tier=Advanced",
+			options: Options{Region: "eu-central-1", Tier: types.ParameterTierAdvanced},
+		},
+		{
+			url: "ssm:///gitlab/pat?region=eu-west-1&profile=security&role-arn=arn:aws:iam::123456789012:role/rotator&kms-key-id=alias/gitlab",
+			options: Options{
+				Region:   "eu-west-1",
+				Profile:  "security",
+				RoleArn:  "arn:aws:iam::123456789012:role/rotator",
+				KmsKeyId: "alias/gitlab",
+			},
+		},
+		{url: "arn:aws:ssm:eu-central-1:123456789012:parameter/gitlab/pat?region=us-east-1", expectError: true},
+		{url: "ssm:///gitlab/pat?tier=Premium", expectError: true},
+	}
+	t.Setenv("AWS_CONFIG_FILE", "testdata/config")
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			ref, err := NewFromURL(context.Background(), u)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("NewFromURL() error = %v, expected error %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+			if options := ref.(*TokenReference).options; options != tt.options {
+				t.Errorf("expected options %+v, got %+v", tt.options, options)
+			}
+		})
+	}
+}
+
+// fakeVersion is a version in the history of the parameter in the fake Parameter Store.
+type fakeVersion struct {
+	value    string
+	labels   []string
+	modified time.Time
+}
+
+// fakeParameterStore is a stand-in for the Parameter Store API, holding a single parameter. It returns
+// the history in pages of two versions.
+type fakeParameterStore struct {
+	t         *testing.T
+	versions  []*fakeVersion
+	tags      map[string]string
+	beforePut func()
+}
+
+// newFakeReference creates a reference to the parameter /gitlab/pat in a fake Parameter Store.
+func newFakeReference(t *testing.T) (*TokenReference, *fakeParameterStore) {
+	fake := &fakeParameterStore{t: t, tags: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := awsssm.New(awsssm.Options{
+		Region:       "eu-central-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("access", "secret", ""),
+	})
+	return &TokenReference{parameterName: "/gitlab/pat", client: client}, fake
+}
+
+// put adds a version holding the value to the history of the parameter.
+func (f *fakeParameterStore) put(value string, labels ...string) int {
+	f.versions = append(f.versions, &fakeVersion{
+		value:    value,
+		labels:   labels,
+		modified: time.Date(2024, 1, 1, 0, len(f.versions), 0, 0, time.UTC),
+	})
+	return len(f.versions)
+}
+
+func (f *fakeParameterStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name             string
+		Value            string
+		Overwrite        bool
+		NextToken        string
+		ParameterVersion int64
+		Labels           []string
+		Tags             []struct{ Key, Value string }
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		f.t.Errorf("invalid request, %v", err)
+	}
+	reply := func(value any) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_ = json.NewEncoder(w).Encode(value)
+	}
+	fail := func(errorType, message string) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"__type": errorType, "message": message})
+	}
+	parameter := func(i int) map[string]any {
+		version := f.versions[i]
+		return map[string]any{
+			"Name":             "/gitlab/pat",
+			"Value":            version.value,
+			"Version":          i + 1,
+			"Labels":           version.labels,
+			"LastModifiedDate": version.modified.Unix(),
+		}
+	}
+
+	switch operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSSM."); operation {
+	case "GetParameter":
+		name, selector, _ := strings.Cut(request.Name, ":")
+		if name != "/gitlab/pat" || len(f.versions) == 0 {
+			fail("ParameterNotFound", "parameter "+name+" not found")
+			return
+		}
+		i := len(f.versions) - 1
+		if selector != "" {
+			version, _ := strconv.Atoi(selector)
+			if i = version - 1; i < 0 || i >= len(f.versions) {
+				fail("ParameterVersionNotFound", "version "+selector+" not found")
+				return
+			}
+		}
+		reply(map[string]any{"Parameter": parameter(i)})
+	case "PutParameter":
+		if len(f.versions) > 0 && !request.Overwrite {
+			fail("ParameterAlreadyExists", "the parameter already exists")
+			return
+		}
+		if f.beforePut != nil {
+			beforePut := f.beforePut
+			f.beforePut = nil
+			beforePut()
+		}
+		for _, tag := range request.Tags {
+			f.tags[tag.Key] = tag.Value
+		}
+		reply(map[string]any{"Version": f.put(request.Value), "Tier": "Standard"})
+	case "GetParameterHistory":
+		start, _ := strconv.Atoi(request.NextToken)
+		end := min(start+2, len(f.versions))
+		parameters := make([]map[string]any, 0, 2)
+		for i := start; i < end; i++ {
+			parameters = append(parameters, parameter(i))
+		}
+		response := map[string]any{"Parameters": parameters}
+		if end < len(f.versions) {
+			response["NextToken"] = strconv.Itoa(end)
+		}
+		reply(response)
+	case "UnlabelParameterVersion":
+		version := f.versions[request.ParameterVersion-1]
+		version.labels = slices.DeleteFunc(version.labels, func(label string) bool {
+			return slices.Contains(request.Labels, label)
+		})
+		reply(map[string]any{"RemovedLabels": request.Labels, "InvalidLabels": []string{}})
+	case "AddTagsToResource":
+		for _, tag := range request.Tags {
+			f.tags[tag.Key] = tag.Value
+		}
+		reply(map[string]any{})
+	case "ListTagsForResource":
+		tags := make([]map[string]string, 0, len(f.tags))
+		for key, value := range f.tags {
+			tags = append(tags, map[string]string{"Key": key, "Value": value})
+		}
+		reply(map[string]any{"TagList": tags})
+	default:
+		f.t.Errorf("unexpected operation %s", operation)
+		fail("InvalidAction", operation)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	for i := 1; i <= 5; i++ {
+		fake.put(fmt.Sprintf("glpat-%d", i), fmt.Sprintf("rotation-%d", i))
+	}
+
+	versions, err := ref.Versions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 5 || versions[0].ID != "5" || !versions[0].Current || versions[4].ID != "1" {
+		t.Fatalf("expected all versions of the history, newest first, got %v", versions)
+	}
+
+	if err = ref.Prune(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	for i, version := range fake.versions {
+		if labelled := len(version.labels) > 0; labelled != (i >= 3) {
+			t.Errorf("expected only the labels of the two newest versions to be kept, version %d has %v", i+1, version.labels)
+		}
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	ctx := context.Background()
+	ref, fake := newFakeReference(t)
+	fake.put("glpat-old")
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	_, version, err := ref.ReadWithVersion(ctx)
+	if err != nil || version != "1" {
+		t.Fatalf("ReadWithVersion() = %s, %v", version, err)
+	}
+
+	// another rotation writes its token, before this one writes
+	fake.put("glpat-winner")
+	if err = ref.UpdateIfVersion(ctx, "glpat-loser", expiresAt, version); !errors.Is(err, secretreference.ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if len(fake.versions) != 2 {
+		t.Errorf("expected no write after the version check, got %d versions", len(fake.versions))
+	}
+
+	// another rotation writes its token, between the version check and the write of this one
+	_, version, _ = ref.ReadWithVersion(ctx)
+	fake.beforePut = func() { fake.put("glpat-racer") }
+	if err = ref.UpdateIfVersion(ctx, "glpat-loser", expiresAt, version); !errors.Is(err, secretreference.ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if token, _ := ref.Read(ctx); token != "glpat-racer" {
+		t.Errorf("expected the token of the winner to be restored, got %s", token)
+	}
+
+	_, version, _ = ref.ReadWithVersion(ctx)
+	if err = ref.UpdateIfVersion(ctx, "glpat-new", expiresAt, version); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := ref.Read(ctx); token != "glpat-new" {
+		t.Errorf("Read() after UpdateIfVersion() = %s", token)
+	}
+	if fake.tags[ExpiresAtTag] != expiresAt.Format(time.RFC3339) {
+		t.Errorf("expected the expiry tag, got %v", fake.tags)
+	}
+}
@@ -0,0 +1,175 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"token-manager/internal/secretreference"
+)
+
+const defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// errNotFound is returned when vault responds with 404 Not Found
+var errNotFound = secretreference.ErrNotFound
+
+// responseError is returned when vault responds with an error status, with the errors from the response body.
+type responseError struct {
+	method     string
+	path       string
+	StatusCode int
+	Errors     []string `json:"errors"`
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("%s %s failed with status %d: %s", e.method, e.path, e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+// client is a minimal Vault HTTP API client, configured in the same way as the vault CLI.
+type client struct {
+	address    string
+	namespace  string
+	httpClient *http.Client
+
+	mutex sync.Mutex
+	token string
+}
+
+// newClient creates a vault client from the VAULT_ADDR and VAULT_NAMESPACE environment variables.
+func newClient() *client {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		address = "https://127.0.0.1:8200"
+	}
+	return &client{
+		address:    strings.TrimSuffix(address, "/"),
+		namespace:  os.Getenv("VAULT_NAMESPACE"),
+		httpClient: http.DefaultClient,
+	}
+}
+
+// authenticate returns a vault token from VAULT_TOKEN, an AppRole login, a Kubernetes login or ~/.vault-token.
+func (c *client) authenticate(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token != "" {
+		return c.token, nil
+	}
+
+	var err error
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		c.token = token
+	} else if roleID := os.Getenv("VAULT_ROLE_ID"); roleID != "" {
+		c.token, err = c.login(ctx, getenv("VAULT_APPROLE_MOUNT", "approle"), map[string]string{
+			"role_id":   roleID,
+			"secret_id": os.Getenv("VAULT_SECRET_ID"),
+		})
+	} else if role := os.Getenv("VAULT_K8S_ROLE"); role != "" {
+		var jwt []byte
+		jwt, err = os.ReadFile(getenv("VAULT_K8S_TOKEN_PATH", defaultKubernetesTokenPath))
+		if err != nil {
+			return "", err
+		}
+		c.token, err = c.login(ctx, getenv("VAULT_K8S_MOUNT", "kubernetes"), map[string]string{
+			"role": role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		})
+	} else if home, homeErr := os.UserHomeDir(); homeErr == nil {
+		var token []byte
+		if token, err = os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+			c.token = strings.TrimSpace(string(token))
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if c.token == "" {
+		return "", errors.New("no vault credentials found, set VAULT_TOKEN, VAULT_ROLE_ID or VAULT_K8S_ROLE")
+	}
+	return c.token, nil
+}
+
+// login logs in using the auth method mounted at mount and returns the client token.
+func (c *client) login(ctx context.Context, mount string, credentials map[string]string) (string, error) {
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := c.request(ctx, "", http.MethodPost, "auth/"+mount+"/login", credentials, &response); err != nil {
+		return "", fmt.Errorf("vault login using %s failed, %w", mount, err)
+	}
+	return response.Auth.ClientToken, nil
+}
+
+// do executes an authenticated request on the vault API.
+func (c *client) do(ctx context.Context, method, path string, body, result any) error {
+	token, err := c.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	return c.request(ctx, token, method, path, body, result)
+}
+
+func (c *client) request(ctx context.Context, token, method, path string, body, result any) error {
+	var content io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.address+"/v1/"+path, content)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+	if c.namespace != "" {
+		request.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if method == http.MethodPatch {
+		request.Header.Set("Content-Type", "application/merge-patch+json")
+	} else if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s, %w", method, path, errNotFound)
+	}
+	if response.StatusCode >= 300 {
+		apiError := &responseError{method: method, path: path, StatusCode: response.StatusCode}
+		_ = json.NewDecoder(response.Body).Decode(apiError)
+		return apiError
+	}
+
+	if result == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func getenv(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
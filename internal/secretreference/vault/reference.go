@@ -0,0 +1,287 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// defaultField is the field of the secret containing the token, if no field is specified.
+const defaultField = "token"
+
+type TokenReference struct {
+	mount        string
+	path         string
+	field        string
+	keepVersions int
+	client       *client
+}
+
+func (t TokenReference) String() string {
+	return fmt.Sprintf("vault://%s/%s#%s", t.mount, t.path, t.field)
+}
+
+// NewTokenReference create a new Hashicorp Vault KV v2 token reference.
+func NewTokenReference(_ context.Context, mount, path, field string) (*TokenReference, error) {
+	if mount == "" || path == "" {
+		return nil, errors.New("a vault mount and secret path are required")
+	}
+	if field == "" {
+		field = defaultField
+	}
+	return &TokenReference{mount: mount, path: path, field: field, client: newClient()}, nil
+}
+
+func NewFromURL(ctx context.Context, referenceURL *url.URL) (secretreference.SecretReference, error) {
+	// vault://secret/gitlab/pat#token
+	if referenceURL.Scheme != "vault" {
+		return nil, fmt.Errorf("unsupported scheme %s", referenceURL.Scheme)
+	}
+	path := strings.Trim(referenceURL.Path, "/")
+	if referenceURL.Host == "" || path == "" {
+		return nil, errors.New("expected an url in the form vault://<mount>/<path>#<field>")
+	}
+	keepVersions, err := secretreference.ParseKeepVersions(referenceURL.Query())
+	if err != nil {
+		return nil, err
+	}
+	ref, err := NewTokenReference(ctx, referenceURL.Host, path, referenceURL.Fragment)
+	if err != nil {
+		return nil, err
+	}
+	ref.keepVersions = keepVersions
+	return ref, nil
+}
+
+// readData reads the key value pairs and the version number of the latest version of the secret.
+func (t TokenReference) readData(ctx context.Context) (map[string]any, int, error) {
+	var response struct {
+		Data struct {
+			Data     map[string]any `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	err := t.client.do(ctx, http.MethodGet, t.mount+"/data/"+t.path, nil, &response)
+	if err != nil {
+		return nil, 0, err
+	}
+	return response.Data.Data, response.Data.Metadata.Version, nil
+}
+
+// Read reads the token from the field of the vault KV v2 secret.
+func (t TokenReference) Read(ctx context.Context) (string, error) {
+	token, _, err := t.ReadWithVersion(ctx)
+	return token, err
+}
+
+// ReadWithVersion reads the token from the field of the vault KV v2 secret, and the version number of the secret.
+func (t TokenReference) ReadWithVersion(ctx context.Context) (string, string, error) {
+	data, version, err := t.readData(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	value, ok := data[t.field].(string)
+	if !ok {
+		return "", "", fmt.Errorf("no field %s found in secret %s", t.field, t)
+	}
+	return value, strconv.Itoa(version), nil
+}
+
+// Update writes a new version of the vault KV v2 secret with the token, retaining the other
+// fields of the secret. The expiry is stored in the custom metadata of the secret, see writeExpiry.
+func (t TokenReference) Update(ctx context.Context, token string, expiresAt time.Time) error {
+	return t.UpdateIfVersion(ctx, token, expiresAt, "")
+}
+
+// UpdateIfVersion writes a new version of the vault KV v2 secret like Update. If a version is
+// given, the check-and-set option makes vault refuse the write when the secret was changed since.
+func (t TokenReference) UpdateIfVersion(ctx context.Context, token string, expiresAt time.Time, version string) error {
+	data, _, err := t.readData(ctx)
+	if err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	if data == nil {
+		data = make(map[string]any)
+	}
+	data[t.field] = token
+
+	body := map[string]any{"data": data}
+	if version != "" {
+		cas, err := strconv.Atoi(version)
+		if err != nil {
+			return fmt.Errorf("invalid version %s for secret %s", version, t)
+		}
+		body["options"] = map[string]any{"cas": cas}
+	}
+
+	var response struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	err = t.client.do(ctx, http.MethodPost, t.mount+"/data/"+t.path, body, &response)
+	var apiError *responseError
+	if version != "" && errors.As(err, &apiError) && apiError.StatusCode == http.StatusBadRequest &&
+		slices.ContainsFunc(apiError.Errors, isCheckAndSetError) {
+		return fmt.Errorf("%s, %w", err, secretreference.ErrConflict)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.writeExpiry(ctx, expiresAt, response.Data.Version)
+	return nil
+}
+
+// writeExpiry stores the expiry of the version of the token in the custom metadata of the secret.
+// The token is already stored, the metadata is written on a best effort basis and a failure is logged.
+func (t TokenReference) writeExpiry(ctx context.Context, expiresAt time.Time, version int) {
+	err := t.client.do(ctx, http.MethodPatch, t.mount+"/metadata/"+t.path, map[string]any{
+		"custom_metadata": map[string]string{
+			"expires-at": expiresAt.Format(time.RFC3339),
+			"version":    fmt.Sprintf("%d", version),
+		},
+	}, nil)
+	if err != nil {
+		log.Printf("failed to store the expiry date in the metadata of %s, %s", t, err)
+	}
+}
+
+// isCheckAndSetError returns true for the error in the response body, with which vault refuses a
+// write when the cas option does not match the current version of the secret.
+func isCheckAndSetError(message string) bool {
+	return strings.HasPrefix(message, "check-and-set parameter did not match")
+}
+
+// Create writes the first version of the vault KV v2 secret with the token. The check-and-set
+// option makes vault refuse the write if the secret already exists.
+func (t TokenReference) Create(ctx context.Context, token string, expiresAt time.Time) error {
+	err := t.client.do(ctx, http.MethodPost, t.mount+"/data/"+t.path, map[string]any{
+		"options": map[string]any{"cas": 0},
+		"data":    map[string]any{t.field: token},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	t.writeExpiry(ctx, expiresAt, 1)
+	return nil
+}
+
+// secretMetadata is the metadata of a vault KV v2 secret.
+type secretMetadata struct {
+	CurrentVersion int               `json:"current_version"`
+	UpdatedTime    time.Time         `json:"updated_time"`
+	CustomMetadata map[string]string `json:"custom_metadata"`
+	Versions       map[string]struct {
+		CreatedTime  time.Time `json:"created_time"`
+		DeletionTime string    `json:"deletion_time"`
+		Destroyed    bool      `json:"destroyed"`
+	} `json:"versions"`
+}
+
+// readMetadata reads the metadata of the vault KV v2 secret.
+func (t TokenReference) readMetadata(ctx context.Context) (*secretMetadata, error) {
+	var response struct {
+		Data secretMetadata `json:"data"`
+	}
+	if err := t.client.do(ctx, http.MethodGet, t.mount+"/metadata/"+t.path, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Data, nil
+}
+
+// Metadata reads the expiry of the token from the custom metadata of the vault KV v2 secret.
+func (t TokenReference) Metadata(ctx context.Context) (metadata secretreference.Metadata, err error) {
+	secret, err := t.readMetadata(ctx)
+	if err != nil {
+		return metadata, err
+	}
+
+	metadata.UpdatedAt = secret.UpdatedTime
+	metadata.Version = fmt.Sprintf("%d", secret.CurrentVersion)
+	if expiresAt, ok := secret.CustomMetadata["expires-at"]; ok {
+		if metadata.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+			return metadata, fmt.Errorf("invalid expires-at custom metadata on %s: %w", t, err)
+		}
+	}
+	return metadata, nil
+}
+
+// Versions returns the versions of the vault KV v2 secret which are not deleted or destroyed, newest first.
+func (t TokenReference) Versions(ctx context.Context) ([]secretreference.Version, error) {
+	secret, err := t.readMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []secretreference.Version
+	for id, version := range secret.Versions {
+		if version.Destroyed || version.DeletionTime != "" {
+			continue
+		}
+		versions = append(versions, secretreference.Version{
+			ID:        id,
+			CreatedAt: version.CreatedTime,
+			Current:   id == fmt.Sprintf("%d", secret.CurrentVersion),
+		})
+	}
+	slices.SortFunc(versions, func(a, b secretreference.Version) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return versions, nil
+}
+
+// ReadVersion reads the token from the field of the version of the vault KV v2 secret.
+func (t TokenReference) ReadVersion(ctx context.Context, id string) (string, error) {
+	var response struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	path := t.mount + "/data/" + t.path + "?version=" + url.QueryEscape(id)
+	if err := t.client.do(ctx, http.MethodGet, path, nil, &response); err != nil {
+		return "", err
+	}
+	value, ok := response.Data.Data[t.field].(string)
+	if !ok {
+		return "", fmt.Errorf("no field %s found in version %s of secret %s", t.field, id, t)
+	}
+	return value, nil
+}
+
+// KeepVersions returns the number of versions to keep, from the keep-versions query parameter.
+func (t TokenReference) KeepVersions() int {
+	return t.keepVersions
+}
+
+// Prune destroys the versions of the vault KV v2 secret, except the newest keep versions and the current one.
+func (t TokenReference) Prune(ctx context.Context, keep int) error {
+	versions, err := t.Versions(ctx)
+	if err != nil {
+		return err
+	}
+	var destroy []int
+	for i, version := range versions {
+		if i < keep || version.Current {
+			continue
+		}
+		if n, err := strconv.Atoi(version.ID); err == nil {
+			destroy = append(destroy, n)
+		}
+	}
+	if len(destroy) == 0 {
+		return nil
+	}
+	return t.client.do(ctx, http.MethodPost, t.mount+"/destroy/"+t.path, map[string]any{"versions": destroy}, nil)
+}
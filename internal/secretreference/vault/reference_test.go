@@ -0,0 +1,355 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"token-manager/internal/secretreference"
+)
+
+// fakeVault is a minimal stand-in for the vault KV v2 and AppRole API.
+type fakeVault struct {
+	token     string
+	data      map[string]map[string]any
+	metadata  map[string]map[string]string
+	versions  map[string]int
+	history   map[string][]map[string]any
+	destroyed map[string][]int
+	readOnly  map[string]bool
+	// noMetadata are the secrets whose metadata cannot be written.
+	noMetadata map[string]bool
+}
+
+func newFakeVault(token string) *fakeVault {
+	return &fakeVault{
+		token:      token,
+		data:       make(map[string]map[string]any),
+		metadata:   make(map[string]map[string]string),
+		versions:   make(map[string]int),
+		history:    make(map[string][]map[string]any),
+		destroyed:  make(map[string][]int),
+		readOnly:   make(map[string]bool),
+		noMetadata: make(map[string]bool),
+	}
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var credentials map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials["role_id"] != "role" || credentials["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": f.token}})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != f.token {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case strings.HasPrefix(path, "secret/data/"):
+		name := strings.TrimPrefix(path, "secret/data/")
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := f.data[name]
+			current := f.versions[name]
+			if version, err := strconv.Atoi(r.URL.Query().Get("version")); err == nil {
+				ok = version > 0 && version <= len(f.history[name])
+				if ok {
+					data = f.history[name][version-1]
+					current = version
+				}
+			}
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"data":     data,
+				"metadata": map[string]any{"version": current},
+			}})
+		case http.MethodPost:
+			var body struct {
+				Options map[string]int `json:"options"`
+				Data    map[string]any `json:"data"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if f.readOnly[name] {
+				w.WriteHeader(http.StatusForbidden)
+				_ = json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+				return
+			}
+			if cas, ok := body.Options["cas"]; ok && cas != f.versions[name] {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"errors": []string{"check-and-set parameter did not match the current version"},
+				})
+				return
+			}
+			f.data[name] = body.Data
+			f.versions[name]++
+			f.history[name] = append(f.history[name], body.Data)
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"version": f.versions[name]}})
+		}
+	case strings.HasPrefix(path, "secret/metadata/") && r.Method == http.MethodPatch:
+		var body struct {
+			CustomMetadata map[string]string `json:"custom_metadata"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if f.noMetadata[strings.TrimPrefix(path, "secret/metadata/")] {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		f.metadata[strings.TrimPrefix(path, "secret/metadata/")] = body.CustomMetadata
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "secret/metadata/") && r.Method == http.MethodGet:
+		name := strings.TrimPrefix(path, "secret/metadata/")
+		versions := make(map[string]any)
+		for i := range f.history[name] {
+			if slices.Contains(f.destroyed[name], i+1) {
+				continue
+			}
+			createdTime := time.Date(2024, 3, 1, i, 0, 0, 0, time.UTC)
+			versions[strconv.Itoa(i+1)] = map[string]any{"created_time": createdTime, "deletion_time": "", "destroyed": false}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"versions":        versions,
+			"current_version": f.versions[name],
+			"updated_time":    "2024-03-01T12:00:00Z",
+			"custom_metadata": f.metadata[name],
+		}})
+	case strings.HasPrefix(path, "secret/destroy/") && r.Method == http.MethodPost:
+		var body struct {
+			Versions []int `json:"versions"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		name := strings.TrimPrefix(path, "secret/destroy/")
+		f.destroyed[name] = append(f.destroyed[name], body.Versions...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newReference(t *testing.T, referenceURL string) *TokenReference {
+	u, err := url.Parse(referenceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := NewFromURL(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.(*TokenReference)
+}
+
+func TestReadAndUpdate(t *testing.T) {
+	vault := newFakeVault("s.root")
+	vault.data["gitlab/pat"] = map[string]any{"token": "glpat-old", "user": "bot"}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "s.root")
+
+	ctx := context.Background()
+	ref := newReference(t, "vault://secret/gitlab/pat")
+	if ref.String() != "vault://secret/gitlab/pat#token" {
+		t.Errorf("String() = %s", ref)
+	}
+
+	token, err := ref.Read(ctx)
+	if err != nil || token != "glpat-old" {
+		t.Fatalf("Read() = %s, %v", token, err)
+	}
+
+	expiresAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err = ref.Update(ctx, "glpat-new", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ = ref.Read(ctx); token != "glpat-new" {
+		t.Errorf("Read() after Update() = %s", token)
+	}
+	if vault.data["gitlab/pat"]["user"] != "bot" {
+		t.Errorf("Update() did not retain other fields, got %v", vault.data["gitlab/pat"])
+	}
+	if got := vault.metadata["gitlab/pat"]["expires-at"]; got != "2024-06-01T00:00:00Z" {
+		t.Errorf("expected expires-at custom metadata, got %s", got)
+	}
+
+	metadata, err := ref.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.ExpiresAt.Equal(expiresAt) || metadata.Version != "1" {
+		t.Errorf("Metadata() = %+v", metadata)
+	}
+}
+
+func TestUpdateNewSecretUsingAppRole(t *testing.T) {
+	vault := newFakeVault("s.approle")
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_ROLE_ID", "role")
+	t.Setenv("VAULT_SECRET_ID", "secret")
+
+	ref := newReference(t, "vault://secret/gitlab/bot#credential")
+	if err := ref.Update(context.Background(), "glpat-new", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if vault.data["gitlab/bot"]["credential"] != "glpat-new" {
+		t.Errorf("expected credential to be written, got %v", vault.data["gitlab/bot"])
+	}
+}
+
+func TestVersions(t *testing.T) {
+	vault := newFakeVault("s.root")
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "s.root")
+
+	ctx := context.Background()
+	ref := newReference(t, "vault://secret/gitlab/pat")
+	for _, token := range []string{"glpat-1", "glpat-2", "glpat-3"} {
+		if err := ref.Update(ctx, token, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := ref.Versions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].ID != "3" || !versions[0].Current || versions[1].Current {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+	if token, err := ref.ReadVersion(ctx, versions[1].ID); err != nil || token != "glpat-2" {
+		t.Errorf("ReadVersion() = %s, %v", token, err)
+	}
+
+	pruned := newReference(t, "vault://secret/gitlab/pat?keep-versions=2")
+	if err = secretreference.Prune(ctx, pruned, 0); err != nil {
+		t.Fatal(err)
+	}
+	if destroyed := vault.destroyed["gitlab/pat"]; len(destroyed) != 1 || destroyed[0] != 1 {
+		t.Errorf("expected version 1 to be destroyed, got %v", destroyed)
+	}
+	if err = secretreference.Prune(ctx, pruned, 1); err != nil {
+		t.Fatal(err)
+	}
+	if destroyed := vault.destroyed["gitlab/pat"]; len(destroyed) != 2 || destroyed[1] != 2 {
+		t.Errorf("expected the explicit number of versions to keep to destroy version 2, got %v", destroyed)
+	}
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	vault := newFakeVault("s.root")
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "s.root")
+
+	ctx := context.Background()
+	ref := newReference(t, "vault://secret/gitlab/pat")
+	if err := ref.Update(ctx, "glpat-old", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	token, version, err := ref.ReadWithVersion(ctx)
+	if err != nil || token != "glpat-old" || version != "1" {
+		t.Fatalf("ReadWithVersion() = %s, %s, %v", token, version, err)
+	}
+
+	if err = ref.Update(ctx, "glpat-winner", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	err = ref.UpdateIfVersion(ctx, "glpat-loser", time.Now(), version)
+	if !errors.Is(err, secretreference.ErrConflict) {
+		t.Fatalf("UpdateIfVersion() expected a conflict, got %v", err)
+	}
+	if token, _ = ref.Read(ctx); token != "glpat-winner" {
+		t.Errorf("Read() after conflict = %s", token)
+	}
+
+	// a write refused for another reason is no conflict, whatever the path of the secret
+	vault.data["gitlab/check-and-set"] = map[string]any{"token": "glpat-old"}
+	vault.versions["gitlab/check-and-set"] = 1
+	vault.readOnly["gitlab/check-and-set"] = true
+	err = newReference(t, "vault://secret/gitlab/check-and-set").UpdateIfVersion(ctx, "glpat-new", time.Now(), "1")
+	if err == nil || errors.Is(err, secretreference.ErrConflict) {
+		t.Errorf("UpdateIfVersion() expected a permission error, got %v", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	vault := newFakeVault("s.root")
+	vault.data["gitlab/existing"] = map[string]any{"token": "glpat-old"}
+	vault.versions["gitlab/existing"] = 1
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "s.root")
+
+	ctx := context.Background()
+	if err := newReference(t, "vault://secret/gitlab/existing").Create(ctx, "glpat-new", time.Now()); err == nil {
+		t.Errorf("Create() expected an error for an existing secret")
+	}
+	if err := newReference(t, "vault://secret/gitlab/pat").Create(ctx, "glpat-new", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if vault.data["gitlab/pat"]["token"] != "glpat-new" || vault.metadata["gitlab/pat"]["expires-at"] == "" {
+		t.Errorf("unexpected secret after Create(), %v %v", vault.data["gitlab/pat"], vault.metadata["gitlab/pat"])
+	}
+}
+
+func TestUpdateWithoutMetadataPermission(t *testing.T) {
+	vault := newFakeVault("s.root")
+	vault.noMetadata["gitlab/pat"] = true
+	vault.noMetadata["gitlab/new"] = true
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "s.root")
+
+	ctx := context.Background()
+	if err := newReference(t, "vault://secret/gitlab/pat").Update(ctx, "glpat-new", time.Now()); err != nil {
+		t.Errorf("Update() expected the stored token not to fail on the metadata, got %v", err)
+	}
+	if err := newReference(t, "vault://secret/gitlab/new").Create(ctx, "glpat-new", time.Now()); err != nil {
+		t.Errorf("Create() expected the stored token not to fail on the metadata, got %v", err)
+	}
+	if vault.data["gitlab/pat"]["token"] != "glpat-new" || vault.data["gitlab/new"]["token"] != "glpat-new" {
+		t.Errorf("expected the tokens to be stored, got %v", vault.data)
+	}
+}
+
+func TestNewFromURLErrors(t *testing.T) {
+	for _, referenceURL := range []string{"vault:///gitlab/pat", "vault://secret", "op://secret/pat"} {
+		u, _ := url.Parse(referenceURL)
+		if _, err := NewFromURL(context.Background(), u); err == nil {
+			t.Errorf("NewFromURL(%s) expected an error", referenceURL)
+		}
+	}
+}